@@ -765,11 +765,12 @@ export const ServerView = () => {
             [clientID]: isSpeaking(microphoneBuffer.finalAnalyser!, 1),
           }));
         } else {
-          if (!client.streamID || !streamSources[client.streamID]) {
+          // The SFU labels forwarded streams with the owning user's ID
+          const stream = streamSources[clientID] ?? (client.streamID ? streamSources[client.streamID] : undefined);
+          if (!stream) {
             return;
           }
 
-          const stream = streamSources[client.streamID];
           setClientsSpeaking((old) => ({
             ...old,
             [clientID]: isSpeaking(stream.analyser, 1),
@@ -11,7 +11,11 @@ import { useSettings } from "@/settings";
 import { useSockets, useServerManagement } from "@/socket";
 import { handleRateLimitError } from "@/socket/src/utils/rateLimitHandler";
 
-import { SFUConnectionState,SFUInterface, Streams, StreamSources } from "../types/SFU";
+import { ActiveSpeaker, SFUConnectionState,SFUInterface, Streams, StreamSources } from "../types/SFU";
+
+// Signaling protocol version and optional features announced in the SFU hello handshake
+const SFU_PROTOCOL_VERSION = 1;
+const SFU_PROTOCOL_FEATURES = ["typed_errors", "session_closed", "end_of_candidates", "ice_servers", "active_speakers"];
 
 // Connection states for better state management
 // enum ConnectionState {
@@ -57,6 +61,8 @@ function useSfuHook(): SFUInterface {
 
   const [streams, setStreams] = useState<Streams>({});
   const [streamSources, setStreamSources] = useState<StreamSources>({});
+  // Loudest speakers of the room as ranked by the SFU, loudest first
+  const [activeSpeakers, setActiveSpeakers] = useState<ActiveSpeaker[]>([]);
 
   // Enhanced logging for state changes
   useEffect(() => {
@@ -164,6 +170,7 @@ function useSfuHook(): SFUInterface {
     }
     
     isDisconnectingRef.current = true;
+    setActiveSpeakers([]);
 
     // If connecting is in progress, cancel it gracefully
     if (isConnectingRef.current) {
@@ -598,6 +605,16 @@ function useSfuHook(): SFUInterface {
         } catch (error) {
           console.error("❌ Error sending ICE candidate:", error);
         }
+      } else if (!event.candidate && sfuWebSocketRef.current?.readyState === WebSocket.OPEN) {
+        // Gathering finished
+        try {
+          sfuWebSocketRef.current.send(JSON.stringify({
+            event: "end_of_candidates",
+            data: "",
+          }));
+        } catch (error) {
+          console.error("❌ Error sending end of candidates:", error);
+        }
       }
     };
 
@@ -839,13 +856,23 @@ function useSfuHook(): SFUInterface {
         reconnectAttempt = 0; // Reset reconnect attempts on successful connection
         console.log("✅ SFU WebSocket opened:", connectionId);
         
-        // Send join message immediately
+        // Announce our protocol version and features, then join immediately.
+        // The SFU answers the hello before it processes the join.
+        const helloMessage = {
+          event: "hello",
+          data: JSON.stringify({
+            protocol_version: SFU_PROTOCOL_VERSION,
+            features: SFU_PROTOCOL_FEATURES,
+          }),
+        };
+
         const joinMessage = {
           event: "client_join",
           data: JSON.stringify(joinToken),
         };
         
         try {
+          ws.send(JSON.stringify(helloMessage));
           ws.send(JSON.stringify(joinMessage));
         } catch (error) {
           console.error("❌ Error sending join message:", error);
@@ -870,7 +897,24 @@ function useSfuHook(): SFUInterface {
           const message = JSON.parse(event.data);
 
           switch (message.event) {
+            case "hello":
+              console.log("🤝 SFU protocol negotiated:", message.data);
+              break;
+
             case "room_joined":
+              // The SFU hands out its ICE servers, including per-user TURN credentials
+              try {
+                const joined = JSON.parse(message.data);
+                if (joined && Array.isArray(joined.ice_servers) && peerConnectionRef.current) {
+                  peerConnectionRef.current.setConfiguration({
+                    ...peerConnectionRef.current.getConfiguration(),
+                    iceServers: joined.ice_servers,
+                  });
+                }
+              } catch {
+                // Older SFUs send a plain success message
+              }
+
               if (!isResolved) {
                 clearTimeout(timeout);
                 isResolved = true;
@@ -886,7 +930,54 @@ function useSfuHook(): SFUInterface {
                 clearTimeout(timeout);
                 isResolved = true;
                 cleanup();
-                reject(new Error(`SFU room error: ${message.data}`));
+                // Typed errors carry a JSON payload with a code and a user-facing message
+                let errorMessage = message.data;
+                try {
+                  const errorData = JSON.parse(message.data);
+                  if (errorData && typeof errorData.message === "string") {
+                    errorMessage = errorData.message;
+                  }
+                } catch {
+                  // Plain string error
+                }
+                reject(new Error(`SFU room error: ${errorMessage}`));
+              }
+              break;
+
+            case "session_closed":
+              // The SFU ended the session, e.g. it was replaced or the room closed. Don't recover it.
+              let closedReason = "";
+              let closedMessage = message.data;
+              try {
+                const closedData = JSON.parse(message.data);
+                if (closedData && typeof closedData.message === "string") {
+                  closedReason = closedData.reason;
+                  closedMessage = closedData.message;
+                }
+              } catch {
+                // Plain string message
+              }
+              console.warn("🚫 SFU closed the session:", connectionId, closedReason, closedMessage);
+              cleanup();
+              if (!isResolved) {
+                clearTimeout(timeout);
+                isResolved = true;
+                reject(new Error(`SFU session closed: ${closedMessage}`));
+              } else {
+                window.dispatchEvent(new CustomEvent('sfu_session_closed', {
+                  detail: { reason: closedReason, message: closedMessage }
+                }));
+              }
+              break;
+
+            case "active_speakers":
+              try {
+                const speakersData = JSON.parse(message.data);
+                if (speakersData && Array.isArray(speakersData.speakers)) {
+                  setActiveSpeakers(speakersData.speakers);
+                }
+              } catch (error) {
+                console.error("❌ Invalid active speakers message:", error);
               }
               break;
 
@@ -954,6 +1045,15 @@ function useSfuHook(): SFUInterface {
               }
               break;
 
+            case "end_of_candidates":
+              if (peerConnectionRef.current && peerConnectionRef.current.remoteDescription) {
+                peerConnectionRef.current.addIceCandidate()
+                  .catch((error) => {
+                    console.error("❌ Error applying end of candidates:", error);
+                  });
+              }
+              break;
+
             case "candidate":
               const candidate = JSON.parse(message.data);
               if (peerConnectionRef.current && 
@@ -1400,6 +1500,25 @@ function useSfuHook(): SFUInterface {
     };
   }, [disconnect]);
 
+  // Listen for sessions the SFU closed (replaced by another session, kicked, room closed)
+  useEffect(() => {
+    const handleSessionClosed = (event: CustomEvent) => {
+      const { reason, message } = event.detail;
+      console.log(`🚫 SFU session closed, reason: ${reason}`);
+      toast.error(message || "Disconnected from voice server");
+
+      disconnect(false).catch(error => {
+        console.error('❌ Error during SFU session close:', error);
+      });
+    };
+
+    window.addEventListener('sfu_session_closed', handleSessionClosed as EventListener);
+
+    return () => {
+      window.removeEventListener('sfu_session_closed', handleSessionClosed as EventListener);
+    };
+  }, [disconnect]);
+
   // Monitor processedStream changes and update WebRTC tracks
   useEffect(() => {
     if (!isConnected || !peerConnectionRef.current || !registeredTracksRef.current) {
@@ -1482,6 +1601,7 @@ function useSfuHook(): SFUInterface {
     streams,
     error: connectionState.error,
     streamSources,
+    activeSpeakers,
     connect,
     disconnect,
     currentServerConnected: connectionState.serverId || "",
@@ -1496,6 +1616,7 @@ const init: SFUInterface = {
   error: null,
   streams: {},
   streamSources: {},
+  activeSpeakers: [],
   connect: () => Promise.resolve(),
   disconnect: () => Promise.resolve(),
   currentChannelConnected: "",
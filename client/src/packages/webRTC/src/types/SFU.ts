@@ -15,6 +15,12 @@ export type StreamSources = {
   };
 };
 
+// A user ranked among the loudest speakers of a room by the SFU
+export interface ActiveSpeaker {
+  user_id: string;
+  level: number; // Average loudness over the detection window, from 0 to 1
+}
+
 // Connection states for SFU
 export enum SFUConnectionState {
   DISCONNECTED = 'disconnected',
@@ -28,6 +34,7 @@ export interface SFUInterface {
   streams: Streams;
   error: string | null;
   streamSources: StreamSources;
+  activeSpeakers: ActiveSpeaker[];
   connect: (channelID: string) => Promise<void>;
   disconnect: (playSound?: boolean, onDisconnect?: () => void) => Promise<void>;
   currentServerConnected: string;
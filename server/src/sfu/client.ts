@@ -1,6 +1,6 @@
 import WebSocket from 'ws';
 import { consola } from 'consola';
-import { randomBytes } from 'crypto';
+import { createHmac, randomBytes } from 'crypto';
 
 interface ServerRegistrationData {
   server_id: string;
@@ -11,19 +11,47 @@ interface ServerRegistrationData {
 interface ClientJoinData {
   room_id: string;
   server_id: string;
-  server_password: string;
   user_token: string;
 }
 
+// Signaling protocol version and optional features announced in the SFU hello handshake
+const SFU_PROTOCOL_VERSION = 1;
+const SFU_PROTOCOL_FEATURES = ['typed_errors', 'peer_events', 'room_snapshot', 'active_speakers'];
+
+// How long to wait for the SFU to send the join token key after registering
+const JOIN_TOKEN_KEY_TIMEOUT_MS = 10000;
+// Join tokens are single use, so they only need to outlive the initial SFU connection
+const JOIN_TOKEN_TTL_SECONDS = 60;
+
 interface AudioControlData {
   room_id: string;
   user_id: string;
   server_id: string;
-  server_password: string;
   is_muted: boolean;
   is_deafened: boolean;
 }
 
+// Typed payload of room_error messages sent by the SFU
+interface RoomErrorData {
+  code: string;
+  message: string;
+  retryable: boolean;
+  retry_after?: number;
+}
+
+// Key the SFU hands out on registration and credential rotation for signing join tokens
+interface JoinTokenKeyData {
+  server_id: string;
+  key: string;
+}
+
+// Loudest speakers of a room pushed by the SFU, loudest first and empty when nobody speaks
+interface ActiveSpeakersData {
+  room_id: string;
+  speakers: Array<{ user_id: string; level: number }>;
+  timestamp: number;
+}
+
 interface WebSocketMessage {
   event: string;
   data: string;
@@ -33,6 +61,9 @@ export class SFUClient {
   private ws: WebSocket | null = null;
   private serverId: string;
   private serverToken: string;
+  // Random key issued by the SFU, unrelated to the server password
+  private joinTokenKey: Buffer | null = null;
+  private joinTokenKeyWaiters: Array<() => void> = [];
   private sfuHost: string;
   private reconnectAttempts = 0;
   // Fixed 10s reconnect delay as requested
@@ -91,6 +122,15 @@ export class SFUClient {
             this.reconnectTimer = null;
           }
           
+          // Negotiate the protocol before sending anything else
+          this.ws?.send(JSON.stringify({
+            event: 'hello',
+            data: JSON.stringify({
+              protocol_version: SFU_PROTOCOL_VERSION,
+              features: SFU_PROTOCOL_FEATURES,
+            }),
+          }));
+
           // Re-register rooms after successful reconnection
           this.reregisterRooms().catch(error => {
             consola.error('Failed to re-register rooms after reconnection:', error);
@@ -226,19 +266,94 @@ export class SFUClient {
     this.connectionHealth.lastPing = Date.now();
     
     switch (message.event) {
+      case 'hello':
+        consola.info('SFU protocol negotiated:', message.data);
+        break;
+
+      case 'join_token_key':
+        this.handleJoinTokenKey(message.data);
+        break;
+
+      case 'active_speakers':
+        this.handleActiveSpeakers(message.data);
+        break;
+
       case 'room_joined':
         consola.success('SFU Room Registration Success:', message.data);
         break;
         
-      case 'room_error':
-        consola.error('SFU Room Error:', message.data);
+      case 'room_error': {
+        const roomError = this.parseRoomError(message.data);
+        consola.error(`SFU Room Error [${roomError.code}]:`, roomError.message);
         break;
+      }
         
       default:
         consola.debug('SFU Message:', message.event, message.data);
     }
   }
 
+  private handleJoinTokenKey(data: string): void {
+    try {
+      const parsed: JoinTokenKeyData = JSON.parse(data);
+      if (parsed.server_id !== this.serverId || !parsed.key) {
+        consola.warn('Ignoring SFU join token key for another server');
+        return;
+      }
+      this.joinTokenKey = Buffer.from(parsed.key, 'base64url');
+    } catch (error) {
+      consola.error('Invalid SFU join token key:', error);
+      return;
+    }
+
+    consola.info(`Received SFU join token key for server ${this.serverId}`);
+    const waiters = this.joinTokenKeyWaiters;
+    this.joinTokenKeyWaiters = [];
+    waiters.forEach(resolve => resolve());
+  }
+
+  private handleActiveSpeakers(data: string): void {
+    try {
+      const parsed: ActiveSpeakersData = JSON.parse(data);
+      if (!parsed.room_id || !Array.isArray(parsed.speakers)) {
+        return;
+      }
+      consola.debug(`Active speakers in ${parsed.room_id}:`, parsed.speakers.map(speaker => speaker.user_id));
+    } catch (error) {
+      consola.error('Invalid SFU active speakers message:', error);
+    }
+  }
+
+  private waitForJoinTokenKey(): Promise<Buffer> {
+    if (this.joinTokenKey) {
+      return Promise.resolve(this.joinTokenKey);
+    }
+
+    return new Promise((resolve, reject) => {
+      const waiter = () => {
+        clearTimeout(timeout);
+        resolve(this.joinTokenKey as Buffer);
+      };
+      const timeout = setTimeout(() => {
+        this.joinTokenKeyWaiters = this.joinTokenKeyWaiters.filter(w => w !== waiter);
+        reject(new Error('SFU did not send a join token key, is the server registered?'));
+      }, JOIN_TOKEN_KEY_TIMEOUT_MS);
+      this.joinTokenKeyWaiters.push(waiter);
+    });
+  }
+
+  private parseRoomError(data: string): RoomErrorData {
+    try {
+      const parsed = JSON.parse(data);
+      if (parsed && typeof parsed.code === 'string') {
+        return parsed as RoomErrorData;
+      }
+    } catch {
+      // Older SFUs send plain strings
+    }
+    return { code: 'unknown', message: data, retryable: false };
+  }
+
   private async reregisterRooms(): Promise<void> {
     if (this.roomsToReregister.size === 0) {
       return;
@@ -303,38 +418,35 @@ export class SFUClient {
     await this.internalRegisterRoom(roomId);
   }
 
-  async unregisterRoom(roomId: string): Promise<void> {
-    // Remove from both current and persistent storage
-    this.registeredRooms.delete(roomId);
-    this.roomsToReregister.delete(roomId);
-    consola.info(`Unregistered room ${roomId} from SFU client`);
-  }
-
-  generateClientJoinToken(roomId: string, userId: string): ClientJoinData {
+  async generateClientJoinToken(roomId: string, userId: string): Promise<ClientJoinData> {
     if (!roomId || !userId) {
       throw new Error('Room ID and User ID are required for token generation');
     }
 
-    // Generate a secure user token (in production, use proper JWT)
-    const userToken = this.generateSecureToken(userId, roomId);
+    // Tokens are signed with the key the SFU sent when we registered
+    const key = await this.waitForJoinTokenKey();
+    const userToken = this.generateSecureToken(userId, roomId, key);
 
     return {
       room_id: roomId,
       server_id: this.serverId,
-      server_password: this.serverToken,
       user_token: userToken,
     };
   }
 
-  private generateSecureToken(userId: string, roomId: string): string {
-    // Generate a cryptographically secure token
-    // In production, this should be a proper JWT with expiration
-    const timestamp = Date.now();
-    const randomData = randomBytes(16).toString('hex');
-    const payload = `${userId}:${roomId}:${timestamp}:${randomData}`;
-    
-    // Simple encoding for now - in production use proper JWT signing
-    return Buffer.from(payload).toString('base64');
+  private generateSecureToken(userId: string, roomId: string, key: Buffer): string {
+    // Signed "payload.signature" token verified by the SFU, so clients never see the server password
+    const claims = {
+      uid: userId,
+      rid: roomId,
+      sid: this.serverId,
+      exp: Math.floor(Date.now() / 1000) + JOIN_TOKEN_TTL_SECONDS,
+      nonce: randomBytes(16).toString('hex'),
+    };
+
+    const payload = Buffer.from(JSON.stringify(claims)).toString('base64url');
+    const signature = createHmac('sha256', key).update(payload).digest('base64url');
+    return `${payload}.${signature}`;
   }
 
   async updateUserAudioState(roomId: string, userId: string, isMuted: boolean, isDeafened: boolean): Promise<void> {
@@ -351,7 +463,6 @@ export class SFUClient {
       room_id: roomId,
       user_id: userId,
       server_id: this.serverId,
-      server_password: this.serverToken,
       is_muted: isMuted,
       is_deafened: isDeafened,
     };
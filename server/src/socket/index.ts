@@ -504,7 +504,7 @@ export function socketHandler(io: Server, socket: Socket, sfuClient: SFUClient |
         await sfuClient.registerRoom(uniqueRoomId);
         
         // Generate secure join token with unique room ID
-        const joinToken = sfuClient.generateClientJoinToken(uniqueRoomId, clientId);
+        const joinToken = await sfuClient.generateClientJoinToken(uniqueRoomId, clientId);
         
         // Send structured response to client with unique room ID
         socket.emit('room_access_granted', {
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"sfu-v2/internal/config"
 	"sfu-v2/internal/recovery"
+	"sfu-v2/internal/relay"
 	"sfu-v2/internal/room"
 	"sfu-v2/internal/signaling"
 	"sfu-v2/internal/track"
 	"sfu-v2/internal/webrtc"
 	"sfu-v2/internal/websocket"
+	"sfu-v2/pkg/types"
 )
 
 func main() {
@@ -46,6 +54,28 @@ func main() {
 	log.Printf("🚀 Starting SFU Server")
 	log.Printf("📊 Configuration: Port=%s, Debug=%t, VerboseLog=%t", cfg.Port, cfg.Debug, cfg.VerboseLog)
 	log.Printf("🧊 ICE Servers: %v", cfg.STUNServers)
+	if cfg.TURNSecret != "" {
+		log.Printf("🔁 TURN servers with REST credentials (TTL %s): %v", cfg.TURNCredentialTTL, cfg.TURNServers)
+	} else if len(cfg.TURNServers) > 0 {
+		log.Printf("🔁 TURN servers with static credentials: %v", cfg.TURNServers)
+	}
+	if cfg.ICEUDPMuxPort > 0 {
+		log.Printf("🧊 ICE UDP mux on port %d", cfg.ICEUDPMuxPort)
+	} else if cfg.ICEPortMin > 0 {
+		log.Printf("🧊 ICE UDP port range %d-%d", cfg.ICEPortMin, cfg.ICEPortMax)
+	}
+	if cfg.ICETCPMuxPort > 0 {
+		log.Printf("🧊 ICE TCP mux on port %d", cfg.ICETCPMuxPort)
+	}
+	if len(cfg.NAT1To1IPs) > 0 {
+		log.Printf("🧊 NAT 1:1 IPs: %v", cfg.NAT1To1IPs)
+	}
+	log.Printf("🎚️  Codecs: audio=%v, video=%v (Opus stereo=%t, FEC=%t, DTX=%t)", cfg.AudioCodecs, cfg.VideoCodecs, cfg.OpusStereo, cfg.OpusFEC, cfg.OpusDTX)
+	if len(cfg.TrustedServers) > 0 {
+		log.Printf("🔒 Server allowlist enabled: %d trusted servers", len(cfg.TrustedServers))
+	} else {
+		log.Printf("⚠️  No server allowlist configured - any server may register")
+	}
 
 	if cfg.Debug {
 		log.Printf("🔍 Debug mode enabled - detailed logging active")
@@ -69,7 +99,7 @@ func main() {
 
 	// Initialize track manager with recovery
 	err = recovery.SafeExecute("MAIN", "INIT_TRACK_MANAGER", func() error {
-		trackManager = track.NewManager(cfg.Debug)
+		trackManager = track.NewManager(cfg.Debug, cfg.MaxTotalTracks)
 		log.Printf("✅ Track manager initialized (debug: %t)", cfg.Debug)
 		return nil
 	})
@@ -78,8 +108,30 @@ func main() {
 	}
 
 	// Initialize WebRTC manager with recovery
+	var iceMuxes io.Closer
 	err = recovery.SafeExecute("MAIN", "INIT_WEBRTC_MANAGER", func() error {
-		webrtcManager = webrtc.NewManager(cfg.Debug)
+		api, closer, err := webrtc.NewAPI(webrtc.APIConfig{
+			UDPMuxPort:       cfg.ICEUDPMuxPort,
+			TCPMuxPort:       cfg.ICETCPMuxPort,
+			NAT1To1IPs:       cfg.NAT1To1IPs,
+			EphemeralPortMin: cfg.ICEPortMin,
+			EphemeralPortMax: cfg.ICEPortMax,
+			Interfaces:       cfg.ICEInterfaces,
+			MDNSMode:         cfg.ICEMulticastMode,
+			Codecs: webrtc.CodecConfig{
+				AudioCodecs:           cfg.AudioCodecs,
+				VideoCodecs:           cfg.VideoCodecs,
+				OpusStereo:            cfg.OpusStereo,
+				OpusFEC:               cfg.OpusFEC,
+				OpusDTX:               cfg.OpusDTX,
+				OpusMaxAverageBitrate: cfg.OpusMaxAverageBitrate,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		iceMuxes = closer
+		webrtcManager = webrtc.NewManager(cfg.Debug, api)
 		log.Printf("✅ WebRTC manager initialized (debug: %t)", cfg.Debug)
 		return nil
 	})
@@ -89,8 +141,14 @@ func main() {
 
 	// Initialize room manager with recovery
 	err = recovery.SafeExecute("MAIN", "INIT_ROOM_MANAGER", func() error {
-		roomManager = room.NewManager(cfg.Debug)
-		log.Printf("✅ Room manager initialized (debug: %t)", cfg.Debug)
+		roomManager = room.NewManager(cfg.Debug, room.DuplicateSessionPolicy(cfg.DuplicateSessionPolicy), cfg.ServerGracePeriod, cfg.TrustedServers, room.Limits{
+			MaxPeersPerRoom:  cfg.MaxPeersPerRoom,
+			MaxTracksPerRoom: cfg.MaxTracksPerRoom,
+			MaxTotalPeers:    cfg.MaxTotalPeers,
+			MaxTotalTracks:   cfg.MaxTotalTracks,
+			LastN:            cfg.LastN,
+		})
+		log.Printf("✅ Room manager initialized (debug: %t, duplicate sessions: %s)", cfg.Debug, cfg.DuplicateSessionPolicy)
 		return nil
 	})
 	if err != nil {
@@ -118,6 +176,23 @@ func main() {
 		log.Fatalf("❌ Failed to initialize WebSocket handler: %v", err)
 	}
 
+	// Start the embedded TURN relay if enabled
+	var turnRelay *relay.Relay
+	if cfg.TURNRelayEnabled {
+		turnRelay, err = relay.Start(relay.Config{
+			Port:              cfg.TURNRelayPort,
+			PublicIP:          cfg.TURNRelayPublicIP,
+			Realm:             cfg.TURNRelayRealm,
+			Secret:            cfg.TURNSecret,
+			AllowPrivatePeers: cfg.TURNRelayAllowPrivatePeers,
+		}, cfg.Debug)
+		if err != nil {
+			logger.LogAction("MAIN", "RELAY_ERROR", "", "", err.Error())
+			log.Fatalf("❌ Failed to start TURN relay: %v", err)
+		}
+		log.Printf("✅ TURN relay listening on UDP/TCP port %d (public IP: %s)", cfg.TURNRelayPort, cfg.TURNRelayPublicIP)
+	}
+
 	// Start keyframe dispatcher with recovery
 	err = recovery.SafeExecute("MAIN", "START_KEYFRAME_DISPATCHER", func() error {
 		webrtcManager.StartKeyFrameDispatcher()
@@ -128,6 +203,34 @@ func main() {
 		log.Fatalf("❌ Failed to start keyframe dispatcher: %v", err)
 	}
 
+	// Start simulcast layer allocator with recovery
+	err = recovery.SafeExecute("MAIN", "START_LAYER_ALLOCATOR", func() error {
+		trackManager.StartLayerAllocator()
+		log.Printf("✅ Simulcast layer allocator started")
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to start simulcast layer allocator: %v", err)
+	}
+
+	// Start active speaker detection with recovery
+	err = recovery.SafeExecute("MAIN", "START_SPEAKER_DETECTION", func() error {
+		trackManager.StartSpeakerDetection(track.SpeakerConfig{
+			Window:      cfg.ActiveSpeakerWindow,
+			MaxSpeakers: cfg.ActiveSpeakerCount,
+			Threshold:   uint8(cfg.ActiveSpeakerThreshold),
+			LastN: func(roomID string) int {
+				return roomManager.RoomLimits(roomID).LastN
+			},
+		}, wsHandler.BroadcastActiveSpeakers)
+		log.Printf("✅ Active speaker detection started (window: %s, speakers: %d, threshold: -%d dBov, last-N: %d)",
+			cfg.ActiveSpeakerWindow, cfg.ActiveSpeakerCount, cfg.ActiveSpeakerThreshold, cfg.LastN)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to start active speaker detection: %v", err)
+	}
+
 	// Start room cleanup routine with recovery
 	recovery.SafeGoroutine("MAIN", "ROOM_CLEANUP", func() {
 		ticker := time.NewTicker(5 * time.Minute) // Check every 5 minutes
@@ -153,6 +256,22 @@ func main() {
 		w.Write([]byte(`{"status":"healthy","service":"sfu","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
 	})
 
+	// Add stats endpoint for monitoring systems
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := recovery.SafeJSONMarshal(&types.StatsData{
+			ICECandidates: wsHandler.ICECandidateStats(),
+			Codecs:        trackManager.GetCodecStats(),
+			Timestamp:     time.Now().UnixMilli(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(stats)
+	})
+
 	// Handle WebSocket connections with recovery wrapper
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Check if this is a WebSocket upgrade request
@@ -178,6 +297,7 @@ func main() {
 	log.Printf("   📡 /client (explicit WebSocket client endpoint)")
 	log.Printf("   📡 /server (WebSocket server registration endpoint)")
 	log.Printf("   🏥 /health (HTTP health check endpoint)")
+	log.Printf("   📊 /stats (HTTP stats endpoint)")
 
 	// Log initial system stats
 	recovery.LogSystemStats()
@@ -189,8 +309,39 @@ func main() {
 
 	logger.LogAction("MAIN", "SERVER_READY", "", "", "HTTP server starting on port "+cfg.Port)
 
-	if err := http.ListenAndServe(":"+cfg.Port, nil); err != nil {
+	httpServer := &http.Server{Addr: ":" + cfg.Port}
+
+	// Shut down the HTTP server and the TURN relay together on SIGINT/SIGTERM
+	recovery.SafeGoroutine("MAIN", "SHUTDOWN_HANDLER", func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+
+		log.Printf("🛑 Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("⚠️  HTTP server shutdown error: %v", err)
+		}
+	})
+
+	err = httpServer.ListenAndServe()
+
+	if turnRelay != nil {
+		if closeErr := turnRelay.Close(); closeErr != nil {
+			log.Printf("⚠️  TURN relay shutdown error: %v", closeErr)
+		} else {
+			log.Printf("✅ TURN relay stopped")
+		}
+	}
+
+	if closeErr := iceMuxes.Close(); closeErr != nil {
+		log.Printf("⚠️  ICE mux shutdown error: %v", closeErr)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.LogAction("MAIN", "SERVER_ERROR", "", "", err.Error())
 		log.Fatalf("❌ HTTP server failed: %v", err)
 	}
+	log.Printf("👋 SFU Server stopped")
 }
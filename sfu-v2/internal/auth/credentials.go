@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// passwordSaltSize is the size in bytes of the random salt stored with each password hash
+	passwordSaltSize = 16
+	// passwordHashIterations is the PBKDF2 work factor. Server passwords are checked on
+	// registration, rotation and opt-in password joins, so this trades some strength for latency.
+	passwordHashIterations = 10000
+	// passwordHashSize is the size in bytes of the derived password hash
+	passwordHashSize = 32
+)
+
+// PasswordHash is a salted hash of a server password
+type PasswordHash struct {
+	salt []byte
+	hash []byte
+}
+
+// HashPassword creates a salted hash of a password
+func HashPassword(password string) (PasswordHash, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return PasswordHash{}, err
+	}
+
+	return PasswordHash{
+		salt: salt,
+		hash: derivePasswordHash(password, salt),
+	}, nil
+}
+
+// Matches reports whether a password matches the hash, in constant time
+func (p PasswordHash) Matches(password string) bool {
+	if len(p.hash) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(p.hash, derivePasswordHash(password, p.salt)) == 1
+}
+
+// SecretsEqual compares two secrets in constant time, regardless of their lengths
+func SecretsEqual(a, b string) bool {
+	digestA := sha256.Sum256([]byte(a))
+	digestB := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(digestA[:], digestB[:]) == 1
+}
+
+// derivePasswordHash derives the hash of a password with the given salt
+func derivePasswordHash(password string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(password), salt, passwordHashIterations, passwordHashSize, sha256.New)
+}
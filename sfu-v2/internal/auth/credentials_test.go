@@ -0,0 +1,68 @@
+package auth
+
+import "testing"
+
+func TestPasswordHashMatches(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		hash     PasswordHash
+		password string
+		want     bool
+	}{
+		{"same password", hash, "correct horse", true},
+		{"different password", hash, "battery staple", false},
+		{"prefix of the password", hash, "correct", false},
+		{"empty password", hash, "", false},
+		{"zero hash", PasswordHash{}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hash.Matches(tt.password); got != tt.want {
+				t.Errorf("Matches(%q) = %t, want %t", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPasswordSalts(t *testing.T) {
+	first, err := HashPassword("password")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	second, err := HashPassword("password")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+
+	if string(first.salt) == string(second.salt) || string(first.hash) == string(second.hash) {
+		t.Error("hashes of the same password share their salt")
+	}
+	if !second.Matches("password") {
+		t.Error("second hash does not match its password")
+	}
+}
+
+func TestSecretsEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"secret", "secret2", false},
+		{"", "", true},
+		{"", "secret", false},
+	}
+
+	for _, tt := range tests {
+		if got := SecretsEqual(tt.a, tt.b); got != tt.want {
+			t.Errorf("SecretsEqual(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// joinTokenKeySize is the size in bytes of the random key servers sign join tokens with
+const joinTokenKeySize = 32
+
+// maxClockSkew is the tolerance applied to token expiry checks
+const maxClockSkew = 5 * time.Second
+
+// Errors returned when a join token cannot be used
+var (
+	ErrInvalidToken = errors.New("invalid join token")
+	ErrTokenExpired = errors.New("join token expired")
+)
+
+// JoinClaims represents the signed contents of a client join token
+type JoinClaims struct {
+	UserID    string `json:"uid"`
+	RoomID    string `json:"rid"`
+	ServerID  string `json:"sid"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"nonce"`
+}
+
+// Expiry returns the token expiry as a time
+func (c *JoinClaims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// NewJoinTokenKey creates a random HMAC key for signing join tokens.
+// It is unrelated to the server password, so a leaked key does not reveal the password.
+func NewJoinTokenKey() ([]byte, error) {
+	key := make([]byte, joinTokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// IsSignedJoinToken reports whether a token uses the signed "payload.signature" format
+func IsSignedJoinToken(token string) bool {
+	return strings.Count(token, ".") == 1
+}
+
+// SignJoinToken creates a signed join token for the given claims
+func SignJoinToken(claims JoinClaims, key []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + sign(encodedPayload, key), nil
+}
+
+// ParseJoinToken decodes the claims of a join token WITHOUT verifying its signature.
+// It is only used to look up which server's key the token must be verified against.
+func ParseJoinToken(token string) (*JoinClaims, error) {
+	encodedPayload, _, found := strings.Cut(token, ".")
+	if !found {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload: %v", ErrInvalidToken, err)
+	}
+
+	claims := &JoinClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidToken, err)
+	}
+
+	if claims.UserID == "" || claims.RoomID == "" || claims.ServerID == "" || claims.Nonce == "" {
+		return nil, fmt.Errorf("%w: missing required claims", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// VerifyJoinToken checks the signature and expiry of a join token and returns its claims
+func VerifyJoinToken(token string, key []byte, now time.Time) (*JoinClaims, error) {
+	encodedPayload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(sign(encodedPayload, key))) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+	}
+
+	claims, err := ParseJoinToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if now.After(claims.Expiry().Add(maxClockSkew)) {
+		return nil, fmt.Errorf("%w at %s", ErrTokenExpired, claims.Expiry().Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
+
+// sign computes the base64url encoded HMAC-SHA256 of a payload
+func sign(encodedPayload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NonceCache remembers used token nonces until their tokens expire to reject replays
+type NonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonce -> token expiry
+	// byExpiry orders the nonces by token expiry, so expired ones are dropped without a full scan
+	byExpiry nonceHeap
+}
+
+// NewNonceCache creates a new nonce cache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use marks a nonce as used. It returns false if the nonce was already used.
+func (c *NonceCache) Use(nonce string, expiresAt time.Time, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop nonces whose tokens can no longer be presented anyway
+	for len(c.byExpiry) > 0 && now.After(c.byExpiry[0].expiresAt.Add(maxClockSkew)) {
+		expired := heap.Pop(&c.byExpiry).(usedNonce)
+		delete(c.nonces, expired.nonce)
+	}
+
+	if _, used := c.nonces[nonce]; used {
+		return false
+	}
+
+	c.nonces[nonce] = expiresAt
+	heap.Push(&c.byExpiry, usedNonce{nonce: nonce, expiresAt: expiresAt})
+	return true
+}
+
+// usedNonce is a nonce in the expiry queue of a NonceCache
+type usedNonce struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// nonceHeap is a min-heap of used nonces ordered by token expiry
+type nonceHeap []usedNonce
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(usedNonce)) }
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
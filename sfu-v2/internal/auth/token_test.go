@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyJoinToken(t *testing.T) {
+	key, err := NewJoinTokenKey()
+	if err != nil {
+		t.Fatalf("NewJoinTokenKey: %v", err)
+	}
+	otherKey, err := NewJoinTokenKey()
+	if err != nil {
+		t.Fatalf("NewJoinTokenKey: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	valid := JoinClaims{UserID: "alice", RoomID: "room", ServerID: "server", ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "n1"}
+
+	sign := func(claims JoinClaims, key []byte) string {
+		token, err := SignJoinToken(claims, key)
+		if err != nil {
+			t.Fatalf("SignJoinToken: %v", err)
+		}
+		return token
+	}
+	expired := valid
+	expired.ExpiresAt = now.Add(-time.Minute).Unix()
+	withinSkew := valid
+	withinSkew.ExpiresAt = now.Add(-maxClockSkew / 2).Unix()
+	missingNonce := valid
+	missingNonce.Nonce = ""
+	tampered := sign(valid, key)
+	_, signature, _ := strings.Cut(tampered, ".")
+	forged := valid
+	forged.UserID = "mallory"
+	forgedPayload, _, _ := strings.Cut(sign(forged, key), ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", sign(valid, key), nil},
+		{"within clock skew", sign(withinSkew, key), nil},
+		{"expired", sign(expired, key), ErrTokenExpired},
+		{"wrong key", sign(valid, otherKey), ErrInvalidToken},
+		{"swapped payload", forgedPayload + "." + signature, ErrInvalidToken},
+		{"missing claims", sign(missingNonce, key), ErrInvalidToken},
+		{"no signature", base64.RawURLEncoding.EncodeToString([]byte(`{"uid":"alice"}`)), ErrInvalidToken},
+		{"garbage", "not-a-token.sig", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyJoinToken(tt.token, key, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.UserID != "alice" || claims.RoomID != "room" || claims.ServerID != "server" {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestIsSignedJoinToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"payload.signature", true},
+		{"legacy-token", false},
+		{"a.b.c", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSignedJoinToken(tt.token); got != tt.want {
+			t.Errorf("IsSignedJoinToken(%q) = %t, want %t", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestNonceCacheUse(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	expiry := now.Add(time.Minute)
+
+	tests := []struct {
+		name  string
+		nonce string
+		now   time.Time
+		want  bool
+	}{
+		{"first use", "n1", now, true},
+		{"replay", "n1", now.Add(time.Second), false},
+		{"other nonce", "n2", now, true},
+		{"replay within clock skew of expiry", "n1", expiry.Add(maxClockSkew), false},
+		{"reuse after expiry", "n1", expiry.Add(maxClockSkew + time.Second), true},
+	}
+
+	cache := NewNonceCache()
+	for _, tt := range tests {
+		if got := cache.Use(tt.nonce, expiry, tt.now); got != tt.want {
+			t.Errorf("%s: Use(%q) = %t, want %t", tt.name, tt.nonce, got, tt.want)
+		}
+	}
+}
+
+func TestNonceCacheExpiresOutOfOrder(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cache := NewNonceCache()
+
+	// Tokens with a longer lifetime may be used before tokens that expire sooner
+	cache.Use("long", now.Add(time.Hour), now)
+	cache.Use("short", now.Add(time.Minute), now)
+
+	later := now.Add(time.Minute + maxClockSkew + time.Second)
+	cache.Use("trigger", later.Add(time.Minute), later)
+
+	if _, kept := cache.nonces["short"]; kept {
+		t.Error("expired nonce was kept")
+	}
+	if cache.Use("long", now.Add(time.Hour), later) {
+		t.Error("unexpired nonce was accepted again")
+	}
+	if len(cache.nonces) != len(cache.byExpiry) {
+		t.Errorf("got %d nonces but %d queued", len(cache.nonces), len(cache.byExpiry))
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TURNCredentials creates time-limited TURN credentials from a shared secret, following the
+// TURN REST API scheme (coturn "use-auth-secret"): the username is "<expiry>:<userID>" and
+// the password is the base64 encoded HMAC-SHA1 of the username.
+func TURNCredentials(secret, userID string, ttl time.Duration, now time.Time) (string, string) {
+	username := fmt.Sprintf("%d:%s", now.Add(ttl).Unix(), userID)
+	return username, TURNPassword(secret, username)
+}
+
+// TURNPassword derives the TURN REST API password of a username
+func TURNPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TURNUsernameExpiry returns the expiry encoded in a TURN REST API username
+func TURNUsernameExpiry(username string) (time.Time, error) {
+	expiry, _, _ := strings.Cut(username, ":")
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid TURN username %q", username)
+	}
+	return time.Unix(seconds, 0), nil
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	username, password := TURNCredentials("secret", "alice", 10*time.Minute, now)
+	if username != "1700000600:alice" {
+		t.Errorf("username = %q, want %q", username, "1700000600:alice")
+	}
+	// Matches coturn's use-auth-secret: base64(HMAC-SHA1(secret, username))
+	if password != "5PrY1fcLQr5jls8N4ZU7fEnmVNE=" {
+		t.Errorf("password = %q", password)
+	}
+	if password != TURNPassword("secret", username) {
+		t.Error("password does not match TURNPassword of the username")
+	}
+	if password == TURNPassword("other", username) {
+		t.Error("password does not depend on the secret")
+	}
+}
+
+func TestTURNUsernameExpiry(t *testing.T) {
+	tests := []struct {
+		username string
+		want     time.Time
+		wantErr  bool
+	}{
+		{"1700000600:alice", time.Unix(1700000600, 0), false},
+		{"1700000600:user:with:colons", time.Unix(1700000600, 0), false},
+		{"1700000600", time.Unix(1700000600, 0), false},
+		{"alice:1700000600", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := TURNUsernameExpiry(tt.username)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("TURNUsernameExpiry(%q) error = %v, want error %t", tt.username, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("TURNUsernameExpiry(%q) = %v, want %v", tt.username, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/pion/webrtc/v3"
@@ -17,6 +20,56 @@ type Config struct {
 	ICEServers  []webrtc.ICEServer
 	Debug       bool
 	VerboseLog  bool
+	// TURNServers are TURN URLs whose credentials are generated per client from TURNSecret
+	// (TURN REST API). They are only used when TURNSecret is set.
+	TURNServers       []string
+	TURNSecret        string
+	TURNCredentialTTL time.Duration
+	// TURNRelayEnabled runs an embedded TURN relay that accepts the TURNSecret credentials
+	TURNRelayEnabled  bool
+	TURNRelayPort     int
+	TURNRelayPublicIP string
+	TURNRelayRealm    string
+	// TURNRelayAllowPrivatePeers lets relay clients reach loopback, private and link-local addresses
+	TURNRelayAllowPrivatePeers bool
+	// ICE networking of the shared WebRTC API. A UDP mux port serves every peer on one port,
+	// otherwise peers get ports from the ephemeral range. A zero TCP mux port disables ICE-TCP.
+	ICEUDPMuxPort    int
+	ICETCPMuxPort    int
+	ICEPortMin       uint16
+	ICEPortMax       uint16
+	NAT1To1IPs       []string
+	ICEInterfaces    []string
+	ICEMulticastMode string
+	// AudioCodecs and VideoCodecs are the allowed codecs in order of preference
+	AudioCodecs []string
+	VideoCodecs []string
+	// Opus fmtp tuning, zero OpusMaxAverageBitrate leaves the bitrate to the sender
+	OpusStereo            bool
+	OpusFEC               bool
+	OpusDTX               bool
+	OpusMaxAverageBitrate int
+	// Active speaker detection ranks the ActiveSpeakerCount loudest users of a room over a
+	// sliding window. Audio levels at or quieter than ActiveSpeakerThreshold (-dBov) are silence.
+	ActiveSpeakerWindow    time.Duration
+	ActiveSpeakerCount     int
+	ActiveSpeakerThreshold int
+	// AllowPasswordJoin lets clients join with the raw server password instead of a signed join token
+	AllowPasswordJoin bool
+	// DuplicateSessionPolicy is "replace" (evict the old session) or "reject" (refuse the new join)
+	DuplicateSessionPolicy string
+	// ServerGracePeriod is how long a server registration survives its /server connection dropping
+	ServerGracePeriod time.Duration
+	// TrustedServers is an optional allowlist of server IDs and their pre-shared secrets.
+	// When non-empty, only these servers may register.
+	TrustedServers map[string]string
+	// Admission limits, zero means unlimited. Servers may lower the per-room limits at registration.
+	MaxPeersPerRoom  int
+	MaxTracksPerRoom int
+	MaxTotalPeers    int
+	MaxTotalTracks   int
+	// LastN forwards only the audio of the N most active speakers of a room, zero forwards all
+	LastN int
 }
 
 // Load reads configuration from environment variables
@@ -43,6 +96,159 @@ func Load() (*Config, error) {
 		},
 	}
 
+	// Additional ICE servers as a JSON array of {"urls", "username", "credential"} entries
+	if value := os.Getenv("ICE_SERVERS"); value != "" {
+		var extraServers []webrtc.ICEServer
+		if err := json.Unmarshal([]byte(value), &extraServers); err != nil {
+			return nil, fmt.Errorf("invalid ICE_SERVERS: %w", err)
+		}
+		iceServers = append(iceServers, extraServers...)
+	}
+
+	// TURN servers either use static credentials or time-limited ones derived from TURN_SECRET
+	turnServers := splitList(os.Getenv("TURN_SERVERS"))
+	turnSecret := os.Getenv("TURN_SECRET")
+
+	// The embedded relay authenticates with TURN_SECRET and is advertised to clients
+	// unless TURN_SERVERS points elsewhere
+	turnRelayEnabled, _ := strconv.ParseBool(os.Getenv("TURN_RELAY_ENABLED"))
+	turnRelayPort := 3478
+	turnRelayPublicIP := os.Getenv("TURN_RELAY_PUBLIC_IP")
+	turnRelayAllowPrivatePeers, _ := strconv.ParseBool(os.Getenv("TURN_RELAY_ALLOW_PRIVATE_PEERS"))
+	turnRelayRealm := os.Getenv("TURN_RELAY_REALM")
+	if turnRelayRealm == "" {
+		turnRelayRealm = "gryt"
+	}
+	if turnRelayEnabled {
+		if value := os.Getenv("TURN_RELAY_PORT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > 65535 {
+				return nil, fmt.Errorf("invalid TURN_RELAY_PORT %q", value)
+			}
+			turnRelayPort = parsed
+		}
+		if turnRelayPublicIP == "" {
+			return nil, fmt.Errorf("TURN_RELAY_ENABLED requires TURN_RELAY_PUBLIC_IP")
+		}
+		if turnSecret == "" {
+			return nil, fmt.Errorf("TURN_RELAY_ENABLED requires TURN_SECRET")
+		}
+		if len(turnServers) == 0 {
+			relayAddress := fmt.Sprintf("%s:%d", turnRelayPublicIP, turnRelayPort)
+			turnServers = []string{
+				"turn:" + relayAddress + "?transport=udp",
+				"turn:" + relayAddress + "?transport=tcp",
+			}
+		}
+	}
+	if len(turnServers) > 0 && turnSecret == "" {
+		turnUsername := os.Getenv("TURN_USERNAME")
+		turnPassword := os.Getenv("TURN_PASSWORD")
+		if turnUsername == "" || turnPassword == "" {
+			return nil, fmt.Errorf("TURN_SERVERS requires TURN_USERNAME and TURN_PASSWORD, or TURN_SECRET")
+		}
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:       turnServers,
+			Username:   turnUsername,
+			Credential: turnPassword,
+		})
+	}
+	if turnSecret != "" && len(turnServers) == 0 {
+		return nil, fmt.Errorf("TURN_SECRET requires TURN_SERVERS")
+	}
+
+	turnCredentialTTL := 24 * time.Hour
+	if value := os.Getenv("TURN_CREDENTIAL_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid TURN_CREDENTIAL_TTL %q (expected a positive duration)", value)
+		}
+		turnCredentialTTL = parsed
+	}
+
+	icePorts := map[string]int{}
+	for _, name := range []string{"ICE_UDP_MUX_PORT", "ICE_TCP_MUX_PORT", "ICE_PORT_MIN", "ICE_PORT_MAX"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > 65535 {
+			return nil, fmt.Errorf("invalid %s %q (expected a port number)", name, value)
+		}
+		icePorts[name] = parsed
+	}
+	if (icePorts["ICE_PORT_MIN"] == 0) != (icePorts["ICE_PORT_MAX"] == 0) || icePorts["ICE_PORT_MIN"] > icePorts["ICE_PORT_MAX"] {
+		return nil, fmt.Errorf("ICE_PORT_MIN and ICE_PORT_MAX must both be set with ICE_PORT_MIN <= ICE_PORT_MAX")
+	}
+
+	iceMulticastMode := strings.ToLower(os.Getenv("ICE_MDNS_MODE"))
+	if iceMulticastMode == "" {
+		iceMulticastMode = "query"
+	}
+	if iceMulticastMode != "disabled" && iceMulticastMode != "query" && iceMulticastMode != "gather" {
+		return nil, fmt.Errorf("invalid ICE_MDNS_MODE %q (expected \"disabled\", \"query\" or \"gather\")", iceMulticastMode)
+	}
+
+	audioCodecs := splitList(strings.ToLower(os.Getenv("AUDIO_CODECS")))
+	if len(audioCodecs) == 0 {
+		audioCodecs = []string{"opus", "g722", "pcmu", "pcma"}
+	}
+	videoCodecs := splitList(strings.ToLower(os.Getenv("VIDEO_CODECS")))
+	if len(videoCodecs) == 0 {
+		videoCodecs = []string{"vp8", "h264", "av1", "vp9"}
+	}
+
+	// In-band FEC is on by default, matching browsers' Opus defaults
+	opusStereo, _ := strconv.ParseBool(os.Getenv("OPUS_STEREO"))
+	opusDTX, _ := strconv.ParseBool(os.Getenv("OPUS_DTX"))
+	opusFEC := true
+	if value := os.Getenv("OPUS_FEC"); value != "" {
+		opusFEC, _ = strconv.ParseBool(value)
+	}
+	opusMaxAverageBitrate := 0
+	if value := os.Getenv("OPUS_MAX_AVERAGE_BITRATE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 6000 || parsed > 510000 {
+			return nil, fmt.Errorf("invalid OPUS_MAX_AVERAGE_BITRATE %q (expected 6000-510000 bits per second)", value)
+		}
+		opusMaxAverageBitrate = parsed
+	}
+
+	activeSpeakerWindow := 2 * time.Second
+	if value := os.Getenv("ACTIVE_SPEAKER_WINDOW"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid ACTIVE_SPEAKER_WINDOW %q (expected a positive duration)", value)
+		}
+		activeSpeakerWindow = parsed
+	}
+	activeSpeakerCount := 3
+	if value := os.Getenv("ACTIVE_SPEAKER_COUNT"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid ACTIVE_SPEAKER_COUNT %q (expected a positive integer)", value)
+		}
+		activeSpeakerCount = parsed
+	}
+	activeSpeakerThreshold := 50
+	if value := os.Getenv("ACTIVE_SPEAKER_THRESHOLD"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 127 {
+			return nil, fmt.Errorf("invalid ACTIVE_SPEAKER_THRESHOLD %q (expected 1-127 -dBov)", value)
+		}
+		activeSpeakerThreshold = parsed
+	}
+
+	lastN := 0
+	if value := os.Getenv("LAST_N"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid LAST_N %q (expected a non-negative integer)", value)
+		}
+		lastN = parsed
+	}
+
 	// Debug configuration
 	debug, _ := strconv.ParseBool(os.Getenv("DEBUG"))
 	verboseLog, _ := strconv.ParseBool(os.Getenv("VERBOSE_LOG"))
@@ -52,11 +258,139 @@ func Load() (*Config, error) {
 		debug = true
 	}
 
+	// Password joins are a legacy fallback and disabled unless explicitly enabled
+	allowPasswordJoin, _ := strconv.ParseBool(os.Getenv("ALLOW_PASSWORD_JOIN"))
+
+	// Replacing sessions trusts the joining user's identity, which password joins do not verify
+	duplicateSessionPolicy := strings.ToLower(os.Getenv("DUPLICATE_SESSION_POLICY"))
+	if duplicateSessionPolicy == "" {
+		duplicateSessionPolicy = "replace"
+		if allowPasswordJoin {
+			duplicateSessionPolicy = "reject"
+		}
+	}
+	if duplicateSessionPolicy != "replace" && duplicateSessionPolicy != "reject" {
+		return nil, fmt.Errorf("invalid DUPLICATE_SESSION_POLICY %q (expected \"replace\" or \"reject\")", duplicateSessionPolicy)
+	}
+
+	serverGracePeriod := 60 * time.Second
+	if value := os.Getenv("SERVER_GRACE_PERIOD"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid SERVER_GRACE_PERIOD %q (expected a positive duration)", value)
+		}
+		serverGracePeriod = parsed
+	}
+
+	trustedServers, err := loadTrustedServers()
+	if err != nil {
+		return nil, err
+	}
+
+	limits := map[string]int{}
+	for _, name := range []string{"MAX_PEERS_PER_ROOM", "MAX_TRACKS_PER_ROOM", "MAX_TOTAL_PEERS", "MAX_TOTAL_TRACKS"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid %s %q (expected a non-negative integer)", name, value)
+		}
+		limits[name] = parsed
+	}
+
 	return &Config{
 		Port:        port,
 		STUNServers: stunServers,
 		ICEServers:  iceServers,
 		Debug:       debug,
 		VerboseLog:  verboseLog,
+
+		TURNServers:                turnServers,
+		TURNSecret:                 turnSecret,
+		TURNCredentialTTL:          turnCredentialTTL,
+		TURNRelayEnabled:           turnRelayEnabled,
+		TURNRelayPort:              turnRelayPort,
+		TURNRelayPublicIP:          turnRelayPublicIP,
+		TURNRelayRealm:             turnRelayRealm,
+		TURNRelayAllowPrivatePeers: turnRelayAllowPrivatePeers,
+
+		ICEUDPMuxPort:    icePorts["ICE_UDP_MUX_PORT"],
+		ICETCPMuxPort:    icePorts["ICE_TCP_MUX_PORT"],
+		ICEPortMin:       uint16(icePorts["ICE_PORT_MIN"]),
+		ICEPortMax:       uint16(icePorts["ICE_PORT_MAX"]),
+		NAT1To1IPs:       splitList(os.Getenv("NAT_1TO1_IPS")),
+		ICEInterfaces:    splitList(os.Getenv("ICE_INTERFACES")),
+		ICEMulticastMode: iceMulticastMode,
+
+		AudioCodecs:           audioCodecs,
+		VideoCodecs:           videoCodecs,
+		OpusStereo:            opusStereo,
+		OpusFEC:               opusFEC,
+		OpusDTX:               opusDTX,
+		OpusMaxAverageBitrate: opusMaxAverageBitrate,
+
+		ActiveSpeakerWindow:    activeSpeakerWindow,
+		ActiveSpeakerCount:     activeSpeakerCount,
+		ActiveSpeakerThreshold: activeSpeakerThreshold,
+
+		AllowPasswordJoin:      allowPasswordJoin,
+		DuplicateSessionPolicy: duplicateSessionPolicy,
+		ServerGracePeriod:      serverGracePeriod,
+		TrustedServers:         trustedServers,
+
+		MaxPeersPerRoom:  limits["MAX_PEERS_PER_ROOM"],
+		MaxTracksPerRoom: limits["MAX_TRACKS_PER_ROOM"],
+		MaxTotalPeers:    limits["MAX_TOTAL_PEERS"],
+		MaxTotalTracks:   limits["MAX_TOTAL_TRACKS"],
+		LastN:            lastN,
 	}, nil
 }
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadTrustedServers reads the server allowlist from TRUSTED_SERVERS_FILE and TRUSTED_SERVERS.
+// The file holds one "server_id:secret" entry per line, the variable is comma separated.
+func loadTrustedServers() (map[string]string, error) {
+	entries := []string{}
+
+	if path := os.Getenv("TRUSTED_SERVERS_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TRUSTED_SERVERS_FILE: %w", err)
+		}
+		entries = append(entries, strings.Split(string(content), "\n")...)
+	}
+
+	if value := os.Getenv("TRUSTED_SERVERS"); value != "" {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+
+	trustedServers := make(map[string]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		serverID, secret, found := strings.Cut(entry, ":")
+		serverID = strings.TrimSpace(serverID)
+		secret = strings.TrimSpace(secret)
+		if !found || serverID == "" || secret == "" {
+			return nil, fmt.Errorf("invalid trusted server entry %q (expected \"server_id:secret\")", entry)
+		}
+		trustedServers[serverID] = secret
+	}
+
+	return trustedServers, nil
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists every variable Load reads, so tests start from a clean environment
+var configEnv = []string{
+	"PORT", "STUN_SERVERS", "ICE_SERVERS", "DEBUG", "VERBOSE_LOG",
+	"TURN_SERVERS", "TURN_SECRET", "TURN_USERNAME", "TURN_PASSWORD", "TURN_CREDENTIAL_TTL",
+	"TURN_RELAY_ENABLED", "TURN_RELAY_PORT", "TURN_RELAY_PUBLIC_IP", "TURN_RELAY_REALM", "TURN_RELAY_ALLOW_PRIVATE_PEERS",
+	"ICE_UDP_MUX_PORT", "ICE_TCP_MUX_PORT", "ICE_PORT_MIN", "ICE_PORT_MAX", "NAT_1TO1_IPS", "ICE_INTERFACES", "ICE_MDNS_MODE",
+	"AUDIO_CODECS", "VIDEO_CODECS", "OPUS_STEREO", "OPUS_FEC", "OPUS_DTX", "OPUS_MAX_AVERAGE_BITRATE",
+	"ACTIVE_SPEAKER_WINDOW", "ACTIVE_SPEAKER_COUNT", "ACTIVE_SPEAKER_THRESHOLD",
+	"ALLOW_PASSWORD_JOIN", "DUPLICATE_SESSION_POLICY", "SERVER_GRACE_PERIOD",
+	"TRUSTED_SERVERS", "TRUSTED_SERVERS_FILE",
+	"MAX_PEERS_PER_ROOM", "MAX_TRACKS_PER_ROOM", "MAX_TOTAL_PEERS", "MAX_TOTAL_TRACKS", "LAST_N",
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Port != "5005" || !cfg.Debug || cfg.AllowPasswordJoin {
+					t.Errorf("got port %s, debug %t, password join %t", cfg.Port, cfg.Debug, cfg.AllowPasswordJoin)
+				}
+				if cfg.DuplicateSessionPolicy != "replace" || cfg.ServerGracePeriod != time.Minute {
+					t.Errorf("got policy %s, grace period %s", cfg.DuplicateSessionPolicy, cfg.ServerGracePeriod)
+				}
+				if cfg.MaxPeersPerRoom != 0 || cfg.MaxTotalTracks != 0 || cfg.LastN != 0 {
+					t.Error("limits are not unlimited by default")
+				}
+				if !cfg.OpusFEC || cfg.ICEMulticastMode != "query" || len(cfg.TrustedServers) != 0 {
+					t.Errorf("got FEC %t, mDNS mode %s, trusted servers %v", cfg.OpusFEC, cfg.ICEMulticastMode, cfg.TrustedServers)
+				}
+			},
+		},
+		{
+			name: "admission limits and Last-N",
+			env:  map[string]string{"MAX_PEERS_PER_ROOM": "10", "MAX_TRACKS_PER_ROOM": "20", "MAX_TOTAL_PEERS": "100", "MAX_TOTAL_TRACKS": "200", "LAST_N": "3"},
+			check: func(t *testing.T, cfg *Config) {
+				got := []int{cfg.MaxPeersPerRoom, cfg.MaxTracksPerRoom, cfg.MaxTotalPeers, cfg.MaxTotalTracks, cfg.LastN}
+				if want := []int{10, 20, 100, 200, 3}; !reflect.DeepEqual(got, want) {
+					t.Errorf("got limits %v, want %v", got, want)
+				}
+			},
+		},
+		{
+			name: "password joins reject duplicate sessions by default",
+			env:  map[string]string{"ALLOW_PASSWORD_JOIN": "true"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DuplicateSessionPolicy != "reject" {
+					t.Errorf("got policy %s, want reject", cfg.DuplicateSessionPolicy)
+				}
+			},
+		},
+		{
+			name: "explicit duplicate session policy",
+			env:  map[string]string{"ALLOW_PASSWORD_JOIN": "true", "DUPLICATE_SESSION_POLICY": "Replace"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DuplicateSessionPolicy != "replace" {
+					t.Errorf("got policy %s, want replace", cfg.DuplicateSessionPolicy)
+				}
+			},
+		},
+		{
+			name: "trusted servers",
+			env:  map[string]string{"TRUSTED_SERVERS": " alpha:secret1 , beta:se:cret2 ,"},
+			check: func(t *testing.T, cfg *Config) {
+				if want := map[string]string{"alpha": "secret1", "beta": "se:cret2"}; !reflect.DeepEqual(cfg.TrustedServers, want) {
+					t.Errorf("got trusted servers %v, want %v", cfg.TrustedServers, want)
+				}
+			},
+		},
+		{
+			name: "TURN relay advertised when no TURN servers are set",
+			env:  map[string]string{"TURN_RELAY_ENABLED": "true", "TURN_RELAY_PUBLIC_IP": "203.0.113.1", "TURN_SECRET": "secret"},
+			check: func(t *testing.T, cfg *Config) {
+				want := []string{"turn:203.0.113.1:3478?transport=udp", "turn:203.0.113.1:3478?transport=tcp"}
+				if !reflect.DeepEqual(cfg.TURNServers, want) || cfg.TURNRelayAllowPrivatePeers {
+					t.Errorf("got TURN servers %v, private peers %t", cfg.TURNServers, cfg.TURNRelayAllowPrivatePeers)
+				}
+			},
+		},
+		{
+			name: "codec lists",
+			env:  map[string]string{"AUDIO_CODECS": "Opus, PCMU", "VIDEO_CODECS": "h264,,vp8"},
+			check: func(t *testing.T, cfg *Config) {
+				if !reflect.DeepEqual(cfg.AudioCodecs, []string{"opus", "pcmu"}) || !reflect.DeepEqual(cfg.VideoCodecs, []string{"h264", "vp8"}) {
+					t.Errorf("got audio codecs %v, video codecs %v", cfg.AudioCodecs, cfg.VideoCodecs)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range configEnv {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"negative room limit", map[string]string{"MAX_PEERS_PER_ROOM": "-1"}, "MAX_PEERS_PER_ROOM"},
+		{"non-numeric SFU limit", map[string]string{"MAX_TOTAL_TRACKS": "many"}, "MAX_TOTAL_TRACKS"},
+		{"negative Last-N", map[string]string{"LAST_N": "-2"}, "LAST_N"},
+		{"zero server grace period", map[string]string{"SERVER_GRACE_PERIOD": "0s"}, "SERVER_GRACE_PERIOD"},
+		{"negative server grace period", map[string]string{"SERVER_GRACE_PERIOD": "-1m"}, "SERVER_GRACE_PERIOD"},
+		{"unknown duplicate session policy", map[string]string{"DUPLICATE_SESSION_POLICY": "ignore"}, "DUPLICATE_SESSION_POLICY"},
+		{"trusted server without secret", map[string]string{"TRUSTED_SERVERS": "alpha"}, "trusted server entry"},
+		{"speaker threshold out of range", map[string]string{"ACTIVE_SPEAKER_THRESHOLD": "128"}, "ACTIVE_SPEAKER_THRESHOLD"},
+		{"ICE port range without maximum", map[string]string{"ICE_PORT_MIN": "50000"}, "ICE_PORT_MIN"},
+		{"TURN relay without public IP", map[string]string{"TURN_RELAY_ENABLED": "true", "TURN_SECRET": "secret"}, "TURN_RELAY_PUBLIC_IP"},
+		{"TURN servers without credentials", map[string]string{"TURN_SERVERS": "turn:turn.example.com"}, "TURN_USERNAME"},
+		{"invalid ICE servers JSON", map[string]string{"ICE_SERVERS": "[{"}, "ICE_SERVERS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range configEnv {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package relay
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/turn/v2"
+
+	"sfu-v2/internal/auth"
+)
+
+// Config configures the embedded TURN relay
+type Config struct {
+	// Port is the UDP and TCP port the relay listens on
+	Port int
+	// PublicIP is the address advertised for relayed candidates
+	PublicIP string
+	Realm    string
+	// Secret is the TURN REST API shared secret the SFU signs client credentials with
+	Secret string
+	// AllowPrivatePeers lets clients relay to loopback, private, link-local and unspecified
+	// addresses. Without it the relay only reaches public addresses.
+	AllowPrivatePeers bool
+}
+
+// Relay is a TURN server embedded in the SFU process. It accepts the same short-lived
+// credentials the SFU hands out to clients in room_joined.
+type Relay struct {
+	server *turn.Server
+	debug  bool
+}
+
+// Start starts a TURN relay listening on UDP and TCP
+func Start(cfg Config, debug bool) (*Relay, error) {
+	publicIP := net.ParseIP(cfg.PublicIP)
+	if publicIP == nil {
+		return nil, fmt.Errorf("invalid TURN relay public IP %q", cfg.PublicIP)
+	}
+
+	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(cfg.Port))
+
+	udpListener, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on UDP %s: %w", address, err)
+	}
+
+	tcpListener, err := net.Listen("tcp4", address)
+	if err != nil {
+		udpListener.Close()
+		return nil, fmt.Errorf("failed to listen on TCP %s: %w", address, err)
+	}
+
+	r := &Relay{debug: debug}
+	permissionHandler := r.permissionHandler(cfg.AllowPrivatePeers)
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: r.authHandler(cfg.Secret),
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:        udpListener,
+				PermissionHandler: permissionHandler,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: publicIP,
+					Address:      "0.0.0.0",
+				},
+			},
+		},
+		ListenerConfigs: []turn.ListenerConfig{
+			{
+				Listener:          tcpListener,
+				PermissionHandler: permissionHandler,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: publicIP,
+					Address:      "0.0.0.0",
+				},
+			},
+		},
+	})
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, fmt.Errorf("failed to start TURN relay: %w", err)
+	}
+
+	r.server = server
+	return r, nil
+}
+
+// Close stops the relay and releases all allocations
+func (r *Relay) Close() error {
+	return r.server.Close()
+}
+
+// AllocationCount returns the number of active relay allocations
+func (r *Relay) AllocationCount() int {
+	return r.server.AllocationCount()
+}
+
+// authHandler accepts TURN REST API credentials signed with the shared secret until they expire
+func (r *Relay) authHandler(secret string) turn.AuthHandler {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		expiry, err := auth.TURNUsernameExpiry(username)
+		if err != nil {
+			r.debugLog("❌ Rejecting TURN allocation from %s: %v", srcAddr, err)
+			return nil, false
+		}
+
+		if time.Now().After(expiry) {
+			r.debugLog("❌ Rejecting TURN allocation from %s: credentials expired at %s", srcAddr, expiry.Format(time.RFC3339))
+			return nil, false
+		}
+
+		return turn.GenerateAuthKey(username, realm, auth.TURNPassword(secret, username)), true
+	}
+}
+
+// permissionHandler only lets clients relay to public peer addresses, so the relay cannot be
+// used to reach the SFU host, its private network or cloud metadata services
+func (r *Relay) permissionHandler(allowPrivatePeers bool) turn.PermissionHandler {
+	return func(clientAddr net.Addr, peerIP net.IP) bool {
+		if allowPrivatePeers || isPublicIP(peerIP) {
+			return true
+		}
+		r.debugLog("❌ Denying TURN permission from %s to non-public peer %s", clientAddr, peerIP)
+		return false
+	}
+}
+
+// isPublicIP reports whether an IP is neither loopback, private, link-local, multicast nor unspecified
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast())
+}
+
+// debugLog logs debug messages if debug mode is enabled
+func (r *Relay) debugLog(format string, args ...interface{}) {
+	if r.debug {
+		log.Printf("[RELAY] "+format, args...)
+	}
+}
@@ -1,6 +1,7 @@
 package room
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -9,36 +10,175 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 
+	"sfu-v2/internal/auth"
 	"sfu-v2/internal/recovery"
 )
 
+// AudioState represents the server-enforced audio state of a user
+type AudioState struct {
+	Muted    bool
+	Deafened bool
+}
+
 // Room represents a voice chat room
 type Room struct {
 	ID              string
 	ServerID        string
-	PeerConnections map[string]*webrtc.PeerConnection
-	Connections     map[string]*websocket.Conn
+	PeerConnections map[string]*webrtc.PeerConnection // userID -> peer connection
+	Connections     map[string]*websocket.Conn        // userID -> websocket connection
+	AudioStates     map[string]AudioState             // userID -> audio state
 	CreatedAt       time.Time
 	LastActivity    time.Time
 	mutex           sync.RWMutex
 }
 
+// newRoom creates an empty room owned by the given server
+func newRoom(roomID, serverID string) *Room {
+	return &Room{
+		ID:              roomID,
+		ServerID:        serverID,
+		PeerConnections: make(map[string]*webrtc.PeerConnection),
+		Connections:     make(map[string]*websocket.Conn),
+		AudioStates:     make(map[string]AudioState),
+		CreatedAt:       time.Now(),
+		LastActivity:    time.Now(),
+	}
+}
+
+// Errors returned by the manager. Callers match them with errors.Is to report a typed error code.
+var (
+	ErrServerNotRegistered = errors.New("server not registered")
+	ErrServerNotTrusted    = errors.New("server is not allowed to register")
+	ErrServerUnavailable   = errors.New("server is disconnected from the SFU")
+	ErrInvalidCredentials  = errors.New("invalid server credentials")
+	ErrInvalidRequest      = errors.New("invalid request")
+	ErrRoomNotFound        = errors.New("room does not exist")
+	ErrRoomOwnership       = errors.New("room belongs to a different server")
+	ErrDuplicateSession    = errors.New("user already has an active session")
+	ErrUserNotInRoom       = errors.New("user is not in the room")
+
+	// Admission errors returned when a join would exceed a capacity limit
+	ErrRoomFull         = errors.New("room is full")
+	ErrServerAtCapacity = errors.New("SFU is at capacity")
+)
+
+// Limits caps peers and tracks per room and across the SFU. Zero means unlimited.
+type Limits struct {
+	MaxPeersPerRoom  int
+	MaxTracksPerRoom int
+	MaxTotalPeers    int
+	MaxTotalTracks   int
+	// LastN caps the speakers whose audio is forwarded in each room
+	LastN int
+}
+
+// RoomLimits are per-room limits a server may request for its rooms at registration.
+// They can only tighten the SFU-wide limits. Zero means no server-specific limit.
+type RoomLimits struct {
+	MaxPeers  int
+	MaxTracks int
+	LastN     int
+}
+
+// minLimit returns the stricter of two limits where zero means unlimited
+func minLimit(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// DuplicateSessionPolicy decides what happens when a user joins while already connected
+type DuplicateSessionPolicy string
+
+const (
+	// DuplicateSessionReject refuses the new join and keeps the existing session
+	DuplicateSessionReject DuplicateSessionPolicy = "reject"
+	// DuplicateSessionReplace accepts the new join and evicts the existing session
+	DuplicateSessionReplace DuplicateSessionPolicy = "replace"
+)
+
+// MemberSnapshot describes a user's session in a room at a point in time
+type MemberSnapshot struct {
+	UserID          string
+	ConnectionState string
+	AudioState      AudioState
+}
+
+// EvictedPeer describes a session that was removed from a room by the SFU rather than by the
+// client leaving, e.g. because a newer session replaced it or the server kicked it
+type EvictedPeer struct {
+	RoomID         string
+	UserID         string
+	PeerConnection *webrtc.PeerConnection
+	Connection     *websocket.Conn
+}
+
+// rotatedJoinTokenKeyLifetime is how long join tokens signed with a server's previous
+// credentials stay valid after a rotation
+const rotatedJoinTokenKeyLifetime = 5 * time.Minute
+
+// serverCredentials holds what the SFU keeps about a registered server's password.
+// The raw password is never stored, and the join token key is random rather than derived from it.
+type serverCredentials struct {
+	passwordHash auth.PasswordHash
+	joinTokenKey []byte
+	// previousJoinTokenKey keeps tokens issued before a rotation valid for a short while
+	previousJoinTokenKey       []byte
+	previousJoinTokenKeyExpiry time.Time
+}
+
+// newServerCredentials hashes a server password and creates a join token key for the registration
+func newServerCredentials(serverPassword string) (*serverCredentials, error) {
+	passwordHash, err := auth.HashPassword(serverPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	joinTokenKey, err := auth.NewJoinTokenKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &serverCredentials{
+		passwordHash: passwordHash,
+		joinTokenKey: joinTokenKey,
+	}, nil
+}
+
 // Manager handles room creation and management
 type Manager struct {
 	rooms             map[string]*Room
 	serverToRooms     map[string][]string
-	registeredServers map[string]string // serverID -> serverPassword
-	mutex             sync.RWMutex
-	debug             bool
+	registeredServers map[string]*serverCredentials
+	duplicatePolicy   DuplicateSessionPolicy
+	// disconnectedServers tracks when a server's /server connection dropped
+	disconnectedServers map[string]time.Time
+	serverGracePeriod   time.Duration
+	// trustedServers is the optional allowlist of server IDs and pre-shared secrets
+	trustedServers map[string]string
+	limits         Limits
+	// serverRoomLimits holds the per-room limits each server requested at registration
+	serverRoomLimits map[string]RoomLimits
+	mutex            sync.RWMutex
+	debug            bool
 }
 
-// NewManager creates a new room manager
-func NewManager(debug bool) *Manager {
+// NewManager creates a new room manager.
+// Registrations of servers whose /server connection stays down longer than serverGracePeriod become stale.
+// If trustedServers is non-empty, only the listed servers may register, using their pre-shared secrets.
+func NewManager(debug bool, duplicatePolicy DuplicateSessionPolicy, serverGracePeriod time.Duration, trustedServers map[string]string, limits Limits) *Manager {
 	return &Manager{
-		rooms:             make(map[string]*Room),
-		serverToRooms:     make(map[string][]string),
-		registeredServers: make(map[string]string),
-		debug:             debug,
+		rooms:               make(map[string]*Room),
+		serverToRooms:       make(map[string][]string),
+		registeredServers:   make(map[string]*serverCredentials),
+		duplicatePolicy:     duplicatePolicy,
+		disconnectedServers: make(map[string]time.Time),
+		serverGracePeriod:   serverGracePeriod,
+		trustedServers:      trustedServers,
+		limits:              limits,
+		serverRoomLimits:    make(map[string]RoomLimits),
+		debug:               debug,
 	}
 }
 
@@ -49,45 +189,89 @@ func (m *Manager) debugLog(format string, args ...interface{}) {
 	}
 }
 
-// RegisterServer registers a server and creates a room for it
-func (m *Manager) RegisterServer(serverID, serverPassword, roomID string) error {
-	return recovery.SafeExecuteWithContext("ROOM_MANAGER", "REGISTER_SERVER", "", roomID, fmt.Sprintf("Server: %s", serverID), func() error {
-		m.mutex.Lock()
-		defer m.mutex.Unlock()
+// RegisterServer registers a server and creates a room for it.
+// The server's requested room limits apply to all of its rooms. It returns the key the server
+// must sign join tokens with, which stays the same until the server rotates its credentials.
+func (m *Manager) RegisterServer(serverID, serverPassword, roomID string, roomLimits RoomLimits) ([]byte, error) {
+	var joinTokenKey []byte
 
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "REGISTER_SERVER", "", roomID, fmt.Sprintf("Server: %s", serverID), func() error {
 		m.debugLog("Attempting to register server '%s' with room '%s'", serverID, roomID)
 
-		// Check if server is already registered
-		if existingPassword, exists := m.registeredServers[serverID]; exists {
-			if existingPassword != serverPassword {
+		// Reject invalid requests before any registration state changes
+		if roomLimits.MaxPeers < 0 || roomLimits.MaxTracks < 0 || roomLimits.LastN < 0 {
+			return fmt.Errorf("%w: room limits for server %s must not be negative", ErrInvalidRequest, serverID)
+		}
+
+		// Verify or hash the password outside the lock, the KDF is deliberately slow
+		m.mutex.RLock()
+		existing := m.registeredServers[serverID]
+		m.mutex.RUnlock()
+
+		var created *serverCredentials
+		if existing != nil {
+			if !existing.passwordHash.Matches(serverPassword) {
 				m.debugLog("❌ Server '%s' registration failed: password mismatch", serverID)
-				return fmt.Errorf("server %s already registered with different password", serverID)
+				return fmt.Errorf("%w: server %s already registered with a different password", ErrInvalidCredentials, serverID)
 			}
+		} else {
+			var err error
+			if created, err = newServerCredentials(serverPassword); err != nil {
+				m.debugLog("❌ Server '%s' registration failed: %v", serverID, err)
+				return fmt.Errorf("failed to store credentials for server %s: %w", serverID, err)
+			}
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		// With an allowlist configured, only trusted servers using their pre-shared secret may register
+		if len(m.trustedServers) > 0 {
+			secret, trusted := m.trustedServers[serverID]
+			if !trusted {
+				m.debugLog("❌ Server '%s' registration refused: not in the trusted server allowlist", serverID)
+				return fmt.Errorf("%w: %s", ErrServerNotTrusted, serverID)
+			}
+			if _, registered := m.registeredServers[serverID]; !registered && !auth.SecretsEqual(secret, serverPassword) {
+				m.debugLog("❌ Server '%s' registration refused: pre-shared secret mismatch", serverID)
+				return fmt.Errorf("%w: %s", ErrServerNotTrusted, serverID)
+			}
+		}
+
+		// The registration may have changed while the password was checked
+		if m.registeredServers[serverID] != existing {
+			m.debugLog("❌ Server '%s' registration failed: credentials changed concurrently", serverID)
+			return fmt.Errorf("%w: credentials of server %s changed during registration", ErrInvalidCredentials, serverID)
+		}
+
+		if room, exists := m.rooms[roomID]; exists && room.ServerID != serverID {
+			m.debugLog("❌ Room '%s' already exists for different server '%s' (requested by '%s')", roomID, room.ServerID, serverID)
+			return fmt.Errorf("%w: room %s already exists for a different server", ErrRoomOwnership, roomID)
+		}
+
+		// Check if server is already registered
+		if existing != nil {
 			m.debugLog("✅ Server '%s' already registered with matching password", serverID)
+			if _, disconnected := m.disconnectedServers[serverID]; disconnected {
+				delete(m.disconnectedServers, serverID)
+				m.debugLog("🔌 Server '%s' reconnected, registration is active again", serverID)
+			}
 		} else {
-			m.registeredServers[serverID] = serverPassword
+			m.registeredServers[serverID] = created
 			m.debugLog("✅ Server '%s' registered successfully", serverID)
 		}
 
+		m.serverRoomLimits[serverID] = roomLimits
+		joinTokenKey = m.registeredServers[serverID].joinTokenKey
+
 		// Check if room already exists
-		if room, exists := m.rooms[roomID]; exists {
-			if room.ServerID != serverID {
-				m.debugLog("❌ Room '%s' already exists for different server '%s' (requested by '%s')", roomID, room.ServerID, serverID)
-				return fmt.Errorf("room %s already exists for different server", roomID)
-			}
+		if _, exists := m.rooms[roomID]; exists {
 			m.debugLog("✅ Room '%s' already exists for server '%s'", roomID, serverID)
 			return nil // Room already exists for this server
 		}
 
 		// Create new room with recovery protection
-		room := &Room{
-			ID:              roomID,
-			ServerID:        serverID,
-			PeerConnections: make(map[string]*webrtc.PeerConnection),
-			Connections:     make(map[string]*websocket.Conn),
-			CreatedAt:       time.Now(),
-			LastActivity:    time.Now(),
-		}
+		room := newRoom(roomID, serverID)
 
 		m.rooms[roomID] = room
 		m.serverToRooms[serverID] = append(m.serverToRooms[serverID], roomID)
@@ -97,61 +281,231 @@ func (m *Manager) RegisterServer(serverID, serverPassword, roomID string) error
 
 		return nil
 	})
+
+	return joinTokenKey, err
 }
 
 // ValidateClientJoin validates that a client can join a room and creates the room if it doesn't exist
 func (m *Manager) ValidateClientJoin(roomID, serverID, serverPassword string) error {
 	return recovery.SafeExecuteWithContext("ROOM_MANAGER", "VALIDATE_CLIENT_JOIN", "", roomID, fmt.Sprintf("Server: %s", serverID), func() error {
+		verified, err := m.checkServerCredentials(serverID, serverPassword)
+		if err != nil {
+			return err
+		}
+
 		m.mutex.Lock() // Use Lock instead of RLock since we might need to create a room
 		defer m.mutex.Unlock()
 
 		m.debugLog("Validating client join: room='%s', server='%s'", roomID, serverID)
 
-		// Check if server is registered
-		registeredPassword, exists := m.registeredServers[serverID]
-		if !exists {
-			m.debugLog("❌ Validation failed: server '%s' not registered", serverID)
-			return fmt.Errorf("server %s not registered", serverID)
+		// Check if server is registered with this password and still connected
+		if err := m.checkCredentialsCurrentLocked(serverID, verified); err != nil {
+			return err
 		}
 
-		if registeredPassword != serverPassword {
-			m.debugLog("❌ Validation failed: invalid password for server '%s'", serverID)
-			return fmt.Errorf("invalid server password for server %s", serverID)
+		if err := m.checkServerActiveLocked(serverID); err != nil {
+			return err
 		}
 
-		// Check if room exists - if not, create it automatically
-		room, exists := m.rooms[roomID]
-		if !exists {
-			m.debugLog("🏠 Room '%s' does not exist, creating it automatically for server '%s'", roomID, serverID)
-
-			// Create new room automatically
-			room = &Room{
-				ID:              roomID,
-				ServerID:        serverID,
-				PeerConnections: make(map[string]*webrtc.PeerConnection),
-				Connections:     make(map[string]*websocket.Conn),
-				CreatedAt:       time.Now(),
-				LastActivity:    time.Now(),
-			}
+		if err := m.ensureRoomLocked(roomID, serverID); err != nil {
+			return err
+		}
 
-			m.rooms[roomID] = room
-			m.serverToRooms[serverID] = append(m.serverToRooms[serverID], roomID)
+		m.debugLog("✅ Client join validation passed for room '%s'", roomID)
+		return nil
+	})
+}
 
-			m.debugLog("✅ Auto-created room '%s' for server '%s' (Total rooms: %d)", roomID, serverID, len(m.rooms))
-			m.logRoomStats()
-		} else {
-			// Check if room belongs to the server
-			if room.ServerID != serverID {
-				m.debugLog("❌ Validation failed: room '%s' belongs to server '%s', not '%s'", roomID, room.ServerID, serverID)
-				return fmt.Errorf("room %s does not belong to server %s", roomID, serverID)
-			}
+// checkServerCredentials verifies that a server is registered with the given password. It runs
+// outside m.mutex because the KDF is deliberately slow, so callers must confirm the verified
+// credentials with checkCredentialsCurrentLocked once they hold the lock.
+func (m *Manager) checkServerCredentials(serverID, serverPassword string) (*serverCredentials, error) {
+	m.mutex.RLock()
+	credentials, exists := m.registeredServers[serverID]
+	m.mutex.RUnlock()
+
+	if !exists {
+		m.debugLog("❌ Validation failed: server '%s' not registered", serverID)
+		return nil, fmt.Errorf("%w: %s", ErrServerNotRegistered, serverID)
+	}
+
+	if !credentials.passwordHash.Matches(serverPassword) {
+		m.debugLog("❌ Validation failed: invalid password for server '%s'", serverID)
+		return nil, fmt.Errorf("%w: invalid password for server %s", ErrInvalidCredentials, serverID)
+	}
+	return credentials, nil
+}
+
+// checkCredentialsCurrentLocked verifies that credentials checked by checkServerCredentials were
+// not rotated or unregistered in the meantime. The caller must hold m.mutex.
+func (m *Manager) checkCredentialsCurrentLocked(serverID string, verified *serverCredentials) error {
+	credentials, exists := m.registeredServers[serverID]
+	if !exists {
+		m.debugLog("❌ Validation failed: server '%s' not registered", serverID)
+		return fmt.Errorf("%w: %s", ErrServerNotRegistered, serverID)
+	}
+	if credentials != verified {
+		m.debugLog("❌ Validation failed: credentials of server '%s' changed", serverID)
+		return fmt.Errorf("%w: credentials of server %s changed", ErrInvalidCredentials, serverID)
+	}
+	return nil
+}
+
+// checkServerActiveLocked verifies that a server is registered and its registration is not stale.
+// The caller must hold m.mutex.
+func (m *Manager) checkServerActiveLocked(serverID string) error {
+	if _, exists := m.registeredServers[serverID]; !exists {
+		m.debugLog("❌ Validation failed: server '%s' not registered", serverID)
+		return fmt.Errorf("%w: %s", ErrServerNotRegistered, serverID)
+	}
+
+	if m.isServerStaleLocked(serverID, time.Now()) {
+		m.debugLog("❌ Validation failed: registration of server '%s' is stale", serverID)
+		return fmt.Errorf("%w: registration of server %s is stale", ErrServerUnavailable, serverID)
+	}
+	return nil
+}
+
+// isServerStaleLocked reports whether a server has been disconnected for longer than the grace period.
+// The caller must hold m.mutex.
+func (m *Manager) isServerStaleLocked(serverID string, now time.Time) bool {
+	disconnectedAt, disconnected := m.disconnectedServers[serverID]
+	return disconnected && now.Sub(disconnectedAt) >= m.serverGracePeriod
+}
+
+// MarkServerDisconnected records that a server's /server connection dropped.
+// Its registration becomes stale unless it re-registers within the grace period.
+func (m *Manager) MarkServerDisconnected(serverID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.registeredServers[serverID]; !exists {
+		return
+	}
+
+	m.disconnectedServers[serverID] = time.Now()
+	m.debugLog("🔌 Server '%s' disconnected, registration goes stale in %v without a reconnect", serverID, m.serverGracePeriod)
+}
+
+// IsServerStale reports whether a server's registration is stale
+func (m *Manager) IsServerStale(serverID string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.isServerStaleLocked(serverID, time.Now())
+}
+
+// ValidateTokenJoin validates a client join that was authorized by a signed join token.
+// The token signature has already been verified against the server's join token key.
+func (m *Manager) ValidateTokenJoin(roomID, serverID string) error {
+	return recovery.SafeExecuteWithContext("ROOM_MANAGER", "VALIDATE_TOKEN_JOIN", "", roomID, fmt.Sprintf("Server: %s", serverID), func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.debugLog("Validating token join: room='%s', server='%s'", roomID, serverID)
+
+		if err := m.checkServerActiveLocked(serverID); err != nil {
+			return err
 		}
 
-		m.debugLog("✅ Client join validation passed for room '%s'", roomID)
+		if err := m.ensureRoomLocked(roomID, serverID); err != nil {
+			return err
+		}
+
+		m.debugLog("✅ Token join validation passed for room '%s'", roomID)
 		return nil
 	})
 }
 
+// JoinTokenKeys returns the keys that join tokens signed by a server may be verified against.
+// Right after a credential rotation this includes the previous key.
+func (m *Manager) JoinTokenKeys(serverID string) ([][]byte, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	credentials, exists := m.registeredServers[serverID]
+	if !exists {
+		return nil, false
+	}
+
+	keys := [][]byte{credentials.joinTokenKey}
+	if credentials.previousJoinTokenKey != nil && time.Now().Before(credentials.previousJoinTokenKeyExpiry) {
+		keys = append(keys, credentials.previousJoinTokenKey)
+	}
+	return keys, true
+}
+
+// RotateServerCredentials atomically replaces a server's password and join token key, returning the new key.
+// Sessions that already joined are unaffected; new joins must use the new credentials.
+func (m *Manager) RotateServerCredentials(serverID, currentPassword, newPassword string) ([]byte, error) {
+	var joinTokenKey []byte
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "ROTATE_CREDENTIALS", "", "", fmt.Sprintf("Server: %s", serverID), func() error {
+		if newPassword == "" {
+			return fmt.Errorf("%w: new password for server %s must not be empty", ErrInvalidRequest, serverID)
+		}
+
+		verified, err := m.checkServerCredentials(serverID, currentPassword)
+		if err != nil {
+			return err
+		}
+
+		// Hash outside the lock, the KDF is deliberately slow
+		rotated, err := newServerCredentials(newPassword)
+		if err != nil {
+			return fmt.Errorf("failed to store credentials for server %s: %w", serverID, err)
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if err := m.checkCredentialsCurrentLocked(serverID, verified); err != nil {
+			return err
+		}
+
+		if _, trusted := m.trustedServers[serverID]; trusted {
+			m.debugLog("❌ Credential rotation refused for allowlisted server '%s'", serverID)
+			return fmt.Errorf("%w: credentials of allowlisted server %s are managed by the SFU configuration", ErrInvalidRequest, serverID)
+		}
+
+		rotated.previousJoinTokenKey = m.registeredServers[serverID].joinTokenKey
+		rotated.previousJoinTokenKeyExpiry = time.Now().Add(rotatedJoinTokenKeyLifetime)
+		m.registeredServers[serverID] = rotated
+		joinTokenKey = rotated.joinTokenKey
+
+		m.debugLog("🔑 Rotated credentials for server '%s'", serverID)
+		return nil
+	})
+
+	return joinTokenKey, err
+}
+
+// ensureRoomLocked creates a room for a server if it doesn't exist and checks ownership otherwise.
+// The caller must hold m.mutex for writing.
+func (m *Manager) ensureRoomLocked(roomID, serverID string) error {
+	room, exists := m.rooms[roomID]
+	if !exists {
+		m.debugLog("🏠 Room '%s' does not exist, creating it automatically for server '%s'", roomID, serverID)
+
+		// Create new room automatically
+		room = newRoom(roomID, serverID)
+
+		m.rooms[roomID] = room
+		m.serverToRooms[serverID] = append(m.serverToRooms[serverID], roomID)
+
+		m.debugLog("✅ Auto-created room '%s' for server '%s' (Total rooms: %d)", roomID, serverID, len(m.rooms))
+		m.logRoomStats()
+		return nil
+	}
+
+	// Check if room belongs to the server
+	if room.ServerID != serverID {
+		m.debugLog("❌ Validation failed: room '%s' belongs to server '%s', not '%s'", roomID, room.ServerID, serverID)
+		return fmt.Errorf("%w: room %s is not owned by server %s", ErrRoomOwnership, roomID, serverID)
+	}
+	return nil
+}
+
 // GetRoom returns a room by ID
 func (m *Manager) GetRoom(roomID string) (*Room, bool) {
 	var room *Room
@@ -175,73 +529,482 @@ func (m *Manager) GetRoom(roomID string) (*Room, bool) {
 	return room, exists
 }
 
-// AddPeerToRoom adds a peer connection to a room
-func (m *Manager) AddPeerToRoom(roomID, clientID string, pc *webrtc.PeerConnection, conn *websocket.Conn) error {
-	return recovery.SafeExecuteWithContext("ROOM_MANAGER", "ADD_PEER", clientID, roomID, "Adding peer to room", func() error {
+// AddPeerToRoom adds a user's peer connection to a room.
+// If the user already has a session in any room of the same server, the duplicate session
+// policy is applied atomically: the join is rejected, or the existing session is removed
+// and returned so the caller can tear it down.
+func (m *Manager) AddPeerToRoom(roomID, userID string, pc *webrtc.PeerConnection, conn *websocket.Conn) (*EvictedPeer, error) {
+	var replaced *EvictedPeer
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "ADD_PEER", userID, roomID, "Adding peer to room", func() error {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 
 		room, exists := m.rooms[roomID]
 		if !exists {
-			m.debugLog("❌ Cannot add peer '%s': room '%s' does not exist", clientID, roomID)
-			return fmt.Errorf("room %s does not exist", roomID)
+			m.debugLog("❌ Cannot add peer '%s': room '%s' does not exist", userID, roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
 		}
 
 		// Validate inputs
 		if pc == nil {
-			m.debugLog("❌ Cannot add peer '%s': peer connection is nil", clientID)
-			return fmt.Errorf("peer connection is nil for client %s", clientID)
+			m.debugLog("❌ Cannot add peer '%s': peer connection is nil", userID)
+			return fmt.Errorf("peer connection is nil for client %s", userID)
 		}
 
 		if conn == nil {
-			m.debugLog("❌ Cannot add peer '%s': websocket connection is nil", clientID)
-			return fmt.Errorf("websocket connection is nil for client %s", clientID)
+			m.debugLog("❌ Cannot add peer '%s': websocket connection is nil", userID)
+			return fmt.Errorf("websocket connection is nil for client %s", userID)
+		}
+
+		// Look for an existing session of this user on the same server
+		existingRoom := m.findUserRoomLocked(room.ServerID, userID)
+		if existingRoom != nil && m.duplicatePolicy == DuplicateSessionReject {
+			m.debugLog("❌ Rejecting duplicate session for user '%s' (already in room '%s')", userID, existingRoom.ID)
+			return fmt.Errorf("%w: %s", ErrDuplicateSession, userID)
+		}
+
+		// Admission control, not counting a session this join is about to replace
+		roomPeers := m.countPeers(room)
+		totalPeers := m.countTotalPeersLocked()
+		if existingRoom != nil {
+			totalPeers--
+			if existingRoom == room {
+				roomPeers--
+			}
+		}
+
+		if limit := m.roomLimitsLocked(room).MaxPeers; limit > 0 && roomPeers >= limit {
+			m.debugLog("❌ Rejecting peer '%s': room '%s' is full (%d/%d)", userID, roomID, roomPeers, limit)
+			return fmt.Errorf("%w: room %s allows %d participants", ErrRoomFull, roomID, limit)
+		}
+
+		if m.limits.MaxTotalPeers > 0 && totalPeers >= m.limits.MaxTotalPeers {
+			m.debugLog("❌ Rejecting peer '%s': SFU at capacity (%d/%d peers)", userID, totalPeers, m.limits.MaxTotalPeers)
+			return fmt.Errorf("%w: %d participants connected", ErrServerAtCapacity, totalPeers)
+		}
+
+		if existingRoom != nil {
+			existingRoom.mutex.Lock()
+			replaced = &EvictedPeer{
+				RoomID:         existingRoom.ID,
+				UserID:         userID,
+				PeerConnection: existingRoom.PeerConnections[userID],
+				Connection:     existingRoom.Connections[userID],
+			}
+			delete(existingRoom.PeerConnections, userID)
+			delete(existingRoom.Connections, userID)
+			// A replacing session in the same room keeps the user's enforced audio state
+			if existingRoom != room {
+				delete(existingRoom.AudioStates, userID)
+			}
+			existingRoom.LastActivity = time.Now()
+			existingRoom.mutex.Unlock()
+
+			m.debugLog("🔁 Replacing existing session of user '%s' in room '%s'", userID, existingRoom.ID)
 		}
 
 		// Safe room modification
-		return recovery.SafeExecuteWithContext("ROOM_MANAGER", "MODIFY_ROOM", clientID, roomID, "Modifying room state", func() error {
+		return recovery.SafeExecuteWithContext("ROOM_MANAGER", "MODIFY_ROOM", userID, roomID, "Modifying room state", func() error {
 			room.mutex.Lock()
 			defer room.mutex.Unlock()
 
-			room.PeerConnections[clientID] = pc
-			room.Connections[clientID] = conn
+			room.PeerConnections[userID] = pc
+			room.Connections[userID] = conn
 			room.LastActivity = time.Now()
 
-			m.debugLog("👤 Added peer '%s' to room '%s' (Total peers in room: %d)", clientID, roomID, len(room.PeerConnections))
+			m.debugLog("👤 Added peer '%s' to room '%s' (Total peers in room: %d)", userID, roomID, len(room.PeerConnections))
 			m.logRoomDetails(room)
 
 			return nil
 		})
 	})
+
+	if err != nil {
+		return nil, err
+	}
+	return replaced, nil
 }
 
-// RemovePeerFromRoom removes a peer connection from a room
-func (m *Manager) RemovePeerFromRoom(roomID, clientID string) error {
-	return recovery.SafeExecuteWithContext("ROOM_MANAGER", "REMOVE_PEER", clientID, roomID, "Removing peer from room", func() error {
+// RemovePeerFromRoom removes a peer connection from a room.
+// Only the given peer connection is removed, so a session that was already replaced
+// by a newer session of the same user cannot remove its successor.
+func (m *Manager) RemovePeerFromRoom(roomID, userID string, pc *webrtc.PeerConnection) (bool, error) {
+	removed := false
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "REMOVE_PEER", userID, roomID, "Removing peer from room", func() error {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 
 		room, exists := m.rooms[roomID]
 		if !exists {
-			m.debugLog("❌ Cannot remove peer '%s': room '%s' does not exist", clientID, roomID)
-			return fmt.Errorf("room %s does not exist", roomID)
+			m.debugLog("❌ Cannot remove peer '%s': room '%s' does not exist", userID, roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
 		}
 
 		// Safe room modification
-		return recovery.SafeExecuteWithContext("ROOM_MANAGER", "MODIFY_ROOM", clientID, roomID, "Modifying room state", func() error {
+		return recovery.SafeExecuteWithContext("ROOM_MANAGER", "MODIFY_ROOM", userID, roomID, "Modifying room state", func() error {
 			room.mutex.Lock()
 			defer room.mutex.Unlock()
 
-			delete(room.PeerConnections, clientID)
-			delete(room.Connections, clientID)
+			if current, exists := room.PeerConnections[userID]; !exists || current != pc {
+				m.debugLog("⚠️ Peer '%s' in room '%s' was already removed or replaced", userID, roomID)
+				return nil
+			}
+
+			delete(room.PeerConnections, userID)
+			delete(room.Connections, userID)
+			delete(room.AudioStates, userID)
 			room.LastActivity = time.Now()
+			removed = true
 
-			m.debugLog("👤 Removed peer '%s' from room '%s' (Remaining peers: %d)", clientID, roomID, len(room.PeerConnections))
+			m.debugLog("👤 Removed peer '%s' from room '%s' (Remaining peers: %d)", userID, roomID, len(room.PeerConnections))
 			m.logRoomDetails(room)
 
 			return nil
 		})
 	})
+
+	return removed, err
+}
+
+// RoomLimits returns the effective peer, track and Last-N audio limits of a room
+func (m *Manager) RoomLimits(roomID string) RoomLimits {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	room, exists := m.rooms[roomID]
+	if !exists {
+		return RoomLimits{MaxPeers: m.limits.MaxPeersPerRoom, MaxTracks: m.limits.MaxTracksPerRoom, LastN: m.limits.LastN}
+	}
+	return m.roomLimitsLocked(room)
+}
+
+// roomLimitsLocked combines the SFU-wide and server-requested limits of a room.
+// The caller must hold m.mutex.
+func (m *Manager) roomLimitsLocked(room *Room) RoomLimits {
+	serverLimits := m.serverRoomLimits[room.ServerID]
+	return RoomLimits{
+		MaxPeers:  minLimit(m.limits.MaxPeersPerRoom, serverLimits.MaxPeers),
+		MaxTracks: minLimit(m.limits.MaxTracksPerRoom, serverLimits.MaxTracks),
+		LastN:     minLimit(m.limits.LastN, serverLimits.LastN),
+	}
+}
+
+// countPeers returns the number of peers in a room
+func (m *Manager) countPeers(room *Room) int {
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	return len(room.PeerConnections)
+}
+
+// countTotalPeersLocked returns the number of peers across all rooms.
+// The caller must hold m.mutex.
+func (m *Manager) countTotalPeersLocked() int {
+	total := 0
+	for _, room := range m.rooms {
+		total += m.countPeers(room)
+	}
+	return total
+}
+
+// findUserRoomLocked returns the room of a server in which the user currently has a session.
+// The caller must hold m.mutex.
+func (m *Manager) findUserRoomLocked(serverID, userID string) *Room {
+	for _, roomID := range m.serverToRooms[serverID] {
+		room, exists := m.rooms[roomID]
+		if !exists {
+			continue
+		}
+
+		room.mutex.RLock()
+		_, inRoom := room.PeerConnections[userID]
+		room.mutex.RUnlock()
+
+		if inRoom {
+			return room
+		}
+	}
+	return nil
+}
+
+// SetUserAudioState applies a server-driven mute/deafen state to a user in a room.
+// The caller must have authorized serverID. It returns the user's previous state so callers can react to changes.
+func (m *Manager) SetUserAudioState(roomID, serverID, userID string, muted, deafened bool) (AudioState, error) {
+	var previous AudioState
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "SET_AUDIO_STATE", userID, roomID, fmt.Sprintf("Muted: %t, Deafened: %t", muted, deafened), func() error {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+
+		room, exists := m.rooms[roomID]
+		if !exists {
+			m.debugLog("❌ Audio control rejected: room '%s' does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+		}
+
+		if room.ServerID != serverID {
+			m.debugLog("❌ Audio control rejected: room '%s' belongs to server '%s', not '%s'", roomID, room.ServerID, serverID)
+			return fmt.Errorf("%w: room %s is not owned by server %s", ErrRoomOwnership, roomID, serverID)
+		}
+
+		room.mutex.Lock()
+		defer room.mutex.Unlock()
+
+		previous = room.AudioStates[userID]
+		if muted || deafened {
+			room.AudioStates[userID] = AudioState{Muted: muted, Deafened: deafened}
+		} else {
+			delete(room.AudioStates, userID)
+		}
+
+		m.debugLog("🔇 Audio state for user '%s' in room '%s': muted=%t, deafened=%t", userID, roomID, muted, deafened)
+		return nil
+	})
+
+	return previous, err
+}
+
+// GetUserAudioState returns the enforced audio state of a user in a room.
+// It is called for every renegotiation and published track, so it skips the recovery wrapper.
+func (m *Manager) GetUserAudioState(roomID, userID string) AudioState {
+	m.mutex.RLock()
+	room, exists := m.rooms[roomID]
+	m.mutex.RUnlock()
+	if !exists {
+		return AudioState{}
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	return room.AudioStates[userID]
+}
+
+// EvictPeer removes a user's session from a room and returns it so the caller can tear it down
+func (m *Manager) EvictPeer(roomID, userID string) (*EvictedPeer, error) {
+	var evicted *EvictedPeer
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "EVICT_PEER", userID, roomID, "Evicting peer from room", func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		room, exists := m.rooms[roomID]
+		if !exists {
+			m.debugLog("❌ Cannot evict peer '%s': room '%s' does not exist", userID, roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+		}
+
+		room.mutex.Lock()
+		defer room.mutex.Unlock()
+
+		pc, exists := room.PeerConnections[userID]
+		if !exists {
+			m.debugLog("❌ Cannot evict peer '%s': not in room '%s'", userID, roomID)
+			return fmt.Errorf("%w: %s in room %s", ErrUserNotInRoom, userID, roomID)
+		}
+
+		evicted = &EvictedPeer{
+			RoomID:         roomID,
+			UserID:         userID,
+			PeerConnection: pc,
+			Connection:     room.Connections[userID],
+		}
+		delete(room.PeerConnections, userID)
+		delete(room.Connections, userID)
+		delete(room.AudioStates, userID)
+		room.LastActivity = time.Now()
+
+		m.debugLog("🚫 Evicted peer '%s' from room '%s' (Remaining peers: %d)", userID, roomID, len(room.PeerConnections))
+		m.logRoomDetails(room)
+		return nil
+	})
+
+	return evicted, err
+}
+
+// CloseRoom deletes a room and returns all sessions that were in it so the caller can tear them down
+func (m *Manager) CloseRoom(roomID string) ([]EvictedPeer, error) {
+	var evicted []EvictedPeer
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "CLOSE_ROOM", "", roomID, "Closing room", func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if _, exists := m.rooms[roomID]; !exists {
+			m.debugLog("❌ Cannot close room '%s': room does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+		}
+
+		evicted = m.closeRoomLocked(roomID)
+		m.logRoomStats()
+		return nil
+	})
+
+	return evicted, err
+}
+
+// UnregisterRoom removes a room at the request of its owning server and returns the evicted sessions.
+// The caller must have authorized serverID.
+func (m *Manager) UnregisterRoom(serverID, roomID string) ([]EvictedPeer, error) {
+	var evicted []EvictedPeer
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "UNREGISTER_ROOM", "", roomID, fmt.Sprintf("Server: %s", serverID), func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		room, exists := m.rooms[roomID]
+		if !exists {
+			m.debugLog("❌ Cannot unregister room '%s': room does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+		}
+
+		if room.ServerID != serverID {
+			m.debugLog("❌ Cannot unregister room '%s': owned by server '%s', not '%s'", roomID, room.ServerID, serverID)
+			return fmt.Errorf("%w: room %s is not owned by server %s", ErrRoomOwnership, roomID, serverID)
+		}
+
+		evicted = m.closeRoomLocked(roomID)
+		m.debugLog("🗑️  Server '%s' unregistered room '%s'", serverID, roomID)
+		m.logRoomStats()
+		return nil
+	})
+
+	return evicted, err
+}
+
+// UnregisterServer removes a server registration and all of its rooms, returning the evicted sessions.
+// The caller must have authorized serverID. The server's credentials stop working as soon as this returns.
+func (m *Manager) UnregisterServer(serverID string) ([]EvictedPeer, error) {
+	var evicted []EvictedPeer
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "UNREGISTER_SERVER", "", "", fmt.Sprintf("Server: %s", serverID), func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if _, exists := m.registeredServers[serverID]; !exists {
+			m.debugLog("❌ Cannot unregister server '%s': not registered", serverID)
+			return fmt.Errorf("%w: %s", ErrServerNotRegistered, serverID)
+		}
+
+		roomIDs := append([]string{}, m.serverToRooms[serverID]...)
+		for _, roomID := range roomIDs {
+			evicted = append(evicted, m.closeRoomLocked(roomID)...)
+		}
+
+		delete(m.serverToRooms, serverID)
+		delete(m.registeredServers, serverID)
+		delete(m.disconnectedServers, serverID)
+		delete(m.serverRoomLimits, serverID)
+
+		m.debugLog("🗑️  Unregistered server '%s' (%d rooms closed, %d sessions evicted)", serverID, len(roomIDs), len(evicted))
+		m.logRoomStats()
+		return nil
+	})
+
+	return evicted, err
+}
+
+// closeRoomLocked empties and deletes a room, returning the sessions that were in it.
+// The caller must hold m.mutex for writing.
+func (m *Manager) closeRoomLocked(roomID string) []EvictedPeer {
+	room, exists := m.rooms[roomID]
+	if !exists {
+		return nil
+	}
+
+	evicted := []EvictedPeer{}
+	room.mutex.Lock()
+	for userID, pc := range room.PeerConnections {
+		evicted = append(evicted, EvictedPeer{
+			RoomID:         roomID,
+			UserID:         userID,
+			PeerConnection: pc,
+			Connection:     room.Connections[userID],
+		})
+	}
+	room.PeerConnections = make(map[string]*webrtc.PeerConnection)
+	room.Connections = make(map[string]*websocket.Conn)
+	room.AudioStates = make(map[string]AudioState)
+	room.mutex.Unlock()
+
+	m.deleteRoomLocked(roomID)
+
+	m.debugLog("🚪 Closed room '%s' (%d peers evicted, Total rooms: %d)", roomID, len(evicted), len(m.rooms))
+	return evicted
+}
+
+// deleteRoomLocked removes a room and its server-to-rooms entry.
+// The caller must hold m.mutex for writing.
+func (m *Manager) deleteRoomLocked(roomID string) {
+	room, exists := m.rooms[roomID]
+	if !exists {
+		return
+	}
+	serverID := room.ServerID
+
+	delete(m.rooms, roomID)
+
+	if rooms, exists := m.serverToRooms[serverID]; exists {
+		newRooms := []string{}
+		for _, rid := range rooms {
+			if rid != roomID {
+				newRooms = append(newRooms, rid)
+			}
+		}
+		if len(newRooms) == 0 {
+			delete(m.serverToRooms, serverID)
+		} else {
+			m.serverToRooms[serverID] = newRooms
+		}
+	}
+}
+
+// GetRoomsForServer returns the IDs of all rooms owned by a server
+func (m *Manager) GetRoomsForServer(serverID string) []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	rooms := make([]string, len(m.serverToRooms[serverID]))
+	copy(rooms, m.serverToRooms[serverID])
+	return rooms
+}
+
+// SnapshotRoom returns the owning server and current members of a room
+func (m *Manager) SnapshotRoom(roomID string) (string, []MemberSnapshot, error) {
+	var serverID string
+	var members []MemberSnapshot
+
+	err := recovery.SafeExecuteWithContext("ROOM_MANAGER", "SNAPSHOT_ROOM", "", roomID, "Snapshotting room membership", func() error {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+
+		room, exists := m.rooms[roomID]
+		if !exists {
+			m.debugLog("❌ Cannot snapshot: room '%s' does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+		}
+
+		room.mutex.RLock()
+		defer room.mutex.RUnlock()
+
+		serverID = room.ServerID
+		members = make([]MemberSnapshot, 0, len(room.PeerConnections))
+		for userID, pc := range room.PeerConnections {
+			if pc == nil {
+				continue
+			}
+			members = append(members, MemberSnapshot{
+				UserID:          userID,
+				ConnectionState: pc.ConnectionState().String(),
+				AudioState:      room.AudioStates[userID],
+			})
+		}
+
+		m.debugLog("📸 Snapshot of room '%s': %d members", roomID, len(members))
+		return nil
+	})
+
+	return serverID, members, err
 }
 
 // GetPeersInRoom returns all peer connections in a room
@@ -255,7 +1018,7 @@ func (m *Manager) GetPeersInRoom(roomID string) (map[string]*webrtc.PeerConnecti
 		room, exists := m.rooms[roomID]
 		if !exists {
 			m.debugLog("❌ Cannot get peers: room '%s' does not exist", roomID)
-			return fmt.Errorf("room %s does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
 		}
 
 		// Safe room access
@@ -265,9 +1028,9 @@ func (m *Manager) GetPeersInRoom(roomID string) (map[string]*webrtc.PeerConnecti
 
 			// Create a copy to avoid concurrent map access
 			result = make(map[string]*webrtc.PeerConnection)
-			for clientID, pc := range room.PeerConnections {
+			for userID, pc := range room.PeerConnections {
 				if pc != nil { // Only include non-nil peer connections
-					result[clientID] = pc
+					result[userID] = pc
 				}
 			}
 
@@ -293,7 +1056,7 @@ func (m *Manager) GetConnectionsInRoom(roomID string) (map[string]*websocket.Con
 		room, exists := m.rooms[roomID]
 		if !exists {
 			m.debugLog("❌ Cannot get connections: room '%s' does not exist", roomID)
-			return fmt.Errorf("room %s does not exist", roomID)
+			return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
 		}
 
 		// Safe room access
@@ -303,9 +1066,9 @@ func (m *Manager) GetConnectionsInRoom(roomID string) (map[string]*websocket.Con
 
 			// Create a copy to avoid concurrent map access
 			result = make(map[string]*websocket.Conn)
-			for clientID, conn := range room.Connections {
+			for userID, conn := range room.Connections {
 				if conn != nil { // Only include non-nil connections
-					result[clientID] = conn
+					result[userID] = conn
 				}
 			}
 
@@ -328,7 +1091,6 @@ func (m *Manager) CleanupEmptyRooms(maxIdleTime time.Duration) {
 
 		now := time.Now()
 		roomsToDelete := []string{}
-		serversToUpdate := map[string][]string{}
 
 		// Find rooms to delete
 		for roomID, room := range m.rooms {
@@ -352,26 +1114,10 @@ func (m *Manager) CleanupEmptyRooms(maxIdleTime time.Duration) {
 		// Delete marked rooms
 		for _, roomID := range roomsToDelete {
 			recovery.SafeExecuteWithContext("ROOM_MANAGER", "DELETE_ROOM", "", roomID, "Deleting empty room", func() error {
-				room := m.rooms[roomID]
-				serverID := room.ServerID
-
-				delete(m.rooms, roomID)
-
-				// Update server-to-rooms mapping
-				if rooms, exists := m.serverToRooms[serverID]; exists {
-					newRooms := []string{}
-					for _, rid := range rooms {
-						if rid != roomID {
-							newRooms = append(newRooms, rid)
-						}
-					}
-					if len(newRooms) == 0 {
-						delete(m.serverToRooms, serverID)
-					} else {
-						m.serverToRooms[serverID] = newRooms
-					}
-					serversToUpdate[serverID] = newRooms
-				}
+				serverID := m.rooms[roomID].ServerID
+
+				// Removes the room and updates the server-to-rooms mapping
+				m.deleteRoomLocked(roomID)
 
 				m.debugLog("🗑️  Deleted empty room '%s' from server '%s'", roomID, serverID)
 				return nil
@@ -0,0 +1,256 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestMinLimit(t *testing.T) {
+	tests := []struct {
+		a, b int
+		want int
+	}{
+		{0, 0, 0},
+		{0, 5, 5},
+		{5, 0, 5},
+		{3, 5, 3},
+		{5, 3, 3},
+		{4, 4, 4},
+	}
+
+	for _, tt := range tests {
+		if got := minLimit(tt.a, tt.b); got != tt.want {
+			t.Errorf("minLimit(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// newTestPeer creates a peer connection and WebSocket connection to add to a room
+func newTestPeer(t *testing.T) (*webrtc.PeerConnection, *websocket.Conn) {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc, &websocket.Conn{}
+}
+
+func TestAddPeerToRoomAdmission(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     Limits
+		roomLimits RoomLimits
+		policy     DuplicateSessionPolicy
+		// existing lists the users already in room-a and room-b
+		existing map[string][]string
+		roomID   string
+		userID   string
+		wantErr  error
+	}{
+		{
+			name:     "unlimited",
+			existing: map[string][]string{"room-a": {"u1", "u2"}},
+			roomID:   "room-a", userID: "u3",
+		},
+		{
+			name:     "room full",
+			limits:   Limits{MaxPeersPerRoom: 2},
+			existing: map[string][]string{"room-a": {"u1", "u2"}},
+			roomID:   "room-a", userID: "u3",
+			wantErr: ErrRoomFull,
+		},
+		{
+			name:     "other room has space",
+			limits:   Limits{MaxPeersPerRoom: 2},
+			existing: map[string][]string{"room-a": {"u1", "u2"}},
+			roomID:   "room-b", userID: "u3",
+		},
+		{
+			name:       "server limit tighter than the SFU limit",
+			limits:     Limits{MaxPeersPerRoom: 5},
+			roomLimits: RoomLimits{MaxPeers: 1},
+			existing:   map[string][]string{"room-a": {"u1"}},
+			roomID:     "room-a", userID: "u2",
+			wantErr: ErrRoomFull,
+		},
+		{
+			name:       "server limit cannot loosen the SFU limit",
+			limits:     Limits{MaxPeersPerRoom: 1},
+			roomLimits: RoomLimits{MaxPeers: 5},
+			existing:   map[string][]string{"room-a": {"u1"}},
+			roomID:     "room-a", userID: "u2",
+			wantErr: ErrRoomFull,
+		},
+		{
+			name:     "SFU at capacity",
+			limits:   Limits{MaxTotalPeers: 2},
+			existing: map[string][]string{"room-a": {"u1"}, "room-b": {"u2"}},
+			roomID:   "room-b", userID: "u3",
+			wantErr: ErrServerAtCapacity,
+		},
+		{
+			name:     "replaced session is not counted in the room",
+			limits:   Limits{MaxPeersPerRoom: 2},
+			policy:   DuplicateSessionReplace,
+			existing: map[string][]string{"room-a": {"u1", "u2"}},
+			roomID:   "room-a", userID: "u2",
+		},
+		{
+			name:     "replaced session is not counted across the SFU",
+			limits:   Limits{MaxTotalPeers: 2},
+			policy:   DuplicateSessionReplace,
+			existing: map[string][]string{"room-a": {"u1"}, "room-b": {"u2"}},
+			roomID:   "room-a", userID: "u2",
+		},
+		{
+			name:     "duplicate session rejected",
+			policy:   DuplicateSessionReject,
+			existing: map[string][]string{"room-a": {"u1"}},
+			roomID:   "room-b", userID: "u1",
+			wantErr: ErrDuplicateSession,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			if policy == "" {
+				policy = DuplicateSessionReject
+			}
+			m := NewManager(false, policy, time.Minute, nil, tt.limits)
+			for _, roomID := range []string{"room-a", "room-b"} {
+				if _, err := m.RegisterServer("server", "password", roomID, tt.roomLimits); err != nil {
+					t.Fatalf("RegisterServer(%s): %v", roomID, err)
+				}
+			}
+			for roomID, userIDs := range tt.existing {
+				for _, userID := range userIDs {
+					pc, conn := newTestPeer(t)
+					if _, err := m.AddPeerToRoom(roomID, userID, pc, conn); err != nil {
+						t.Fatalf("AddPeerToRoom(%s, %s): %v", roomID, userID, err)
+					}
+				}
+			}
+
+			pc, conn := newTestPeer(t)
+			_, err := m.AddPeerToRoom(tt.roomID, tt.userID, pc, conn)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterServerRejectsNegativeLimits(t *testing.T) {
+	tests := []RoomLimits{
+		{MaxPeers: -1},
+		{MaxTracks: -1},
+		{LastN: -1},
+	}
+
+	for _, roomLimits := range tests {
+		t.Run(fmt.Sprintf("%+v", roomLimits), func(t *testing.T) {
+			m := NewManager(false, DuplicateSessionReject, time.Minute, nil, Limits{})
+			if _, err := m.RegisterServer("server", "password", "room", roomLimits); !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidRequest)
+			}
+			if _, registered := m.registeredServers["server"]; registered {
+				t.Error("server was registered")
+			}
+			if _, exists := m.rooms["room"]; exists {
+				t.Error("room was created")
+			}
+		})
+	}
+}
+
+func TestJoinTokenKeyLifecycle(t *testing.T) {
+	m := NewManager(false, DuplicateSessionReject, time.Minute, nil, Limits{})
+
+	key, err := m.RegisterServer("server", "password", "room-a", RoomLimits{})
+	if err != nil {
+		t.Fatalf("RegisterServer: %v", err)
+	}
+	if len(key) == 0 {
+		t.Fatal("registration returned no join token key")
+	}
+
+	// Registering another room keeps the key, so tokens already handed out stay valid
+	again, err := m.RegisterServer("server", "password", "room-b", RoomLimits{})
+	if err != nil {
+		t.Fatalf("RegisterServer: %v", err)
+	}
+	if !bytes.Equal(again, key) {
+		t.Error("registering another room changed the join token key")
+	}
+
+	rotated, err := m.RotateServerCredentials("server", "password", "new-password")
+	if err != nil {
+		t.Fatalf("RotateServerCredentials: %v", err)
+	}
+	if bytes.Equal(rotated, key) {
+		t.Error("rotation kept the join token key")
+	}
+
+	keys, exists := m.JoinTokenKeys("server")
+	if !exists || len(keys) != 2 || !bytes.Equal(keys[0], rotated) || !bytes.Equal(keys[1], key) {
+		t.Errorf("got join token keys %x, want the rotated key followed by the previous one", keys)
+	}
+}
+
+func TestAudioStateForgottenWhenUserLeaves(t *testing.T) {
+	tests := []struct {
+		name  string
+		leave func(t *testing.T, m *Manager, pc *webrtc.PeerConnection) error
+	}{
+		{"leave", func(_ *testing.T, m *Manager, pc *webrtc.PeerConnection) error {
+			_, err := m.RemovePeerFromRoom("room", "alice", pc)
+			return err
+		}},
+		{"kick", func(_ *testing.T, m *Manager, _ *webrtc.PeerConnection) error {
+			_, err := m.EvictPeer("room", "alice")
+			return err
+		}},
+		{"session moved to another room", func(t *testing.T, m *Manager, _ *webrtc.PeerConnection) error {
+			pc, conn := newTestPeer(t)
+			_, err := m.AddPeerToRoom("other-room", "alice", pc, conn)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(false, DuplicateSessionReplace, time.Minute, nil, Limits{})
+			for _, roomID := range []string{"room", "other-room"} {
+				if _, err := m.RegisterServer("server", "password", roomID, RoomLimits{}); err != nil {
+					t.Fatalf("RegisterServer(%s): %v", roomID, err)
+				}
+			}
+			pc, conn := newTestPeer(t)
+			if _, err := m.AddPeerToRoom("room", "alice", pc, conn); err != nil {
+				t.Fatalf("AddPeerToRoom: %v", err)
+			}
+			if _, err := m.SetUserAudioState("room", "server", "alice", true, true); err != nil {
+				t.Fatalf("SetUserAudioState: %v", err)
+			}
+
+			if err := tt.leave(t, m, pc); err != nil {
+				t.Fatalf("leaving: %v", err)
+			}
+
+			if _, kept := m.rooms["room"].AudioStates["alice"]; kept {
+				t.Error("audio state outlived the session")
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package signaling
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// signalingDebounce is how long a room actor lets triggers accumulate before it negotiates
+	signalingDebounce = 50 * time.Millisecond
+	// syncRetryBaseDelay is the backoff after the first failed sync, doubled on every retry
+	syncRetryBaseDelay = 100 * time.Millisecond
+	// maxSyncAttempts caps how often one batch of triggers is retried
+	maxSyncAttempts = 5
+)
+
+// roomActor serializes signaling for a single room. Triggers queue work and wake the actor;
+// everything queued while it debounces or syncs is coalesced into the next sync.
+// The actor exits once it has no queued work and is recreated by the next trigger.
+type roomActor struct {
+	roomID string
+	wake   chan struct{}
+
+	// Work queued since the last sync, guarded by Coordinator.actorsMu
+	syncAll bool
+	peers   map[*webrtc.PeerConnection]bool
+}
+
+// triggerRoom queues a sync of a room, starting its actor if needed.
+// A nil peer connection syncs the whole room, otherwise only that peer.
+func (c *Coordinator) triggerRoom(roomID string, peerConnection *webrtc.PeerConnection) {
+	c.actorsMu.Lock()
+	defer c.actorsMu.Unlock()
+
+	actor, exists := c.actors[roomID]
+	if !exists {
+		actor = &roomActor{
+			roomID: roomID,
+			wake:   make(chan struct{}, 1),
+			peers:  make(map[*webrtc.PeerConnection]bool),
+		}
+		c.actors[roomID] = actor
+		go c.runRoomActor(actor)
+	}
+
+	actor.queue(peerConnection)
+
+	select {
+	case actor.wake <- struct{}{}:
+	default:
+		// The actor is already due to wake up
+	}
+}
+
+// queue adds a sync of one peer, or of the whole room for a nil peer connection, to the
+// actor's work. The caller must hold Coordinator.actorsMu.
+func (a *roomActor) queue(peerConnection *webrtc.PeerConnection) {
+	if peerConnection == nil {
+		a.syncAll = true
+	} else {
+		a.peers[peerConnection] = true
+	}
+}
+
+// take returns and clears the queued work: the peers to sync, or nil to sync the whole room.
+// The caller must hold Coordinator.actorsMu.
+func (a *roomActor) take() map[*webrtc.PeerConnection]bool {
+	var only map[*webrtc.PeerConnection]bool
+	if !a.syncAll {
+		only = a.peers
+	}
+	a.syncAll = false
+	a.peers = make(map[*webrtc.PeerConnection]bool)
+	return only
+}
+
+// idle reports whether the actor has no queued work. The caller must hold Coordinator.actorsMu.
+func (a *roomActor) idle() bool {
+	return !a.syncAll && len(a.peers) == 0
+}
+
+// syncRetryDelay returns the backoff after a failed sync attempt, starting at 1
+func syncRetryDelay(attempt int) time.Duration {
+	return syncRetryBaseDelay << (attempt - 1)
+}
+
+// markPending remembers a peer that could not be offered because it was mid-negotiation
+func (c *Coordinator) markPending(peerConnection *webrtc.PeerConnection, roomID string) {
+	c.actorsMu.Lock()
+	defer c.actorsMu.Unlock()
+	c.pendingPeers[peerConnection] = roomID
+}
+
+// clearPending forgets a pending peer once it has been offered
+func (c *Coordinator) clearPending(peerConnection *webrtc.PeerConnection) {
+	c.actorsMu.Lock()
+	defer c.actorsMu.Unlock()
+	delete(c.pendingPeers, peerConnection)
+}
+
+// runRoomActor processes the queued work of a room until none is left
+func (c *Coordinator) runRoomActor(actor *roomActor) {
+	for {
+		<-actor.wake
+
+		// Let a burst of triggers (e.g. several tracks of one join) settle into a single sync
+		time.Sleep(signalingDebounce)
+
+		c.actorsMu.Lock()
+		only := actor.take()
+		select {
+		case <-actor.wake:
+		default:
+		}
+		c.actorsMu.Unlock()
+
+		for attempt := 1; ; attempt++ {
+			err := c.syncRoom(actor.roomID, only)
+			if err == nil {
+				c.debugLog("✅ Peer connection signaling completed for room '%s'", actor.roomID)
+				break
+			}
+			if attempt == maxSyncAttempts {
+				c.debugLog("⚠️  Giving up signaling room '%s' after %d attempts: %v", actor.roomID, attempt, err)
+				break
+			}
+
+			delay := syncRetryDelay(attempt)
+			c.debugLog("🔄 Sync attempt %d/%d for room '%s' failed, retrying in %s", attempt, maxSyncAttempts, actor.roomID, delay)
+			time.Sleep(delay)
+		}
+
+		c.actorsMu.Lock()
+		if actor.idle() {
+			delete(c.actors, actor.roomID)
+			c.actorsMu.Unlock()
+			return
+		}
+		c.actorsMu.Unlock()
+	}
+}
@@ -0,0 +1,84 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRoomActorCoalescesTriggers(t *testing.T) {
+	pc1, pc2 := &webrtc.PeerConnection{}, &webrtc.PeerConnection{}
+
+	tests := []struct {
+		name     string
+		triggers []*webrtc.PeerConnection
+		// wantPeers is nil when the whole room is synced
+		wantPeers []*webrtc.PeerConnection
+	}{
+		{"one peer", []*webrtc.PeerConnection{pc1}, []*webrtc.PeerConnection{pc1}},
+		{"same peer twice", []*webrtc.PeerConnection{pc1, pc1}, []*webrtc.PeerConnection{pc1}},
+		{"two peers", []*webrtc.PeerConnection{pc1, pc2}, []*webrtc.PeerConnection{pc1, pc2}},
+		{"whole room", []*webrtc.PeerConnection{nil}, nil},
+		{"whole room absorbs peers", []*webrtc.PeerConnection{pc1, nil, pc2}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A registered actor is woken instead of started, so no sync runs
+			c := &Coordinator{actors: make(map[string]*roomActor)}
+			actor := &roomActor{
+				roomID: "room",
+				wake:   make(chan struct{}, 1),
+				peers:  make(map[*webrtc.PeerConnection]bool),
+			}
+			c.actors["room"] = actor
+
+			for _, pc := range tt.triggers {
+				c.triggerRoom("room", pc)
+			}
+
+			if len(actor.wake) != 1 {
+				t.Errorf("actor woken %d times, want 1", len(actor.wake))
+			}
+
+			only := actor.take()
+			if tt.wantPeers == nil {
+				if only != nil {
+					t.Errorf("synced peers %v, want the whole room", only)
+				}
+			} else {
+				if len(only) != len(tt.wantPeers) {
+					t.Errorf("synced %d peers, want %d", len(only), len(tt.wantPeers))
+				}
+				for _, pc := range tt.wantPeers {
+					if !only[pc] {
+						t.Errorf("peer %p not synced", pc)
+					}
+				}
+			}
+
+			if !actor.idle() {
+				t.Error("actor has work left after take")
+			}
+		})
+	}
+}
+
+func TestSyncRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{maxSyncAttempts - 1, syncRetryBaseDelay << (maxSyncAttempts - 2)},
+	}
+
+	for _, tt := range tests {
+		if got := syncRetryDelay(tt.attempt); got != tt.want {
+			t.Errorf("syncRetryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
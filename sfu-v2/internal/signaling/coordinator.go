@@ -3,7 +3,9 @@ package signaling
 import (
 	"fmt"
 	"log"
+	"sync"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 
 	"sfu-v2/internal/recovery"
@@ -19,6 +21,20 @@ type Coordinator struct {
 	webrtcManager *peerManager.Manager
 	roomManager   *room.Manager
 	debug         bool
+
+	// negotiationLocks serializes offer/answer exchanges per peer connection
+	negotiationMu    sync.Mutex
+	negotiationLocks map[*webrtc.PeerConnection]*sync.Mutex
+
+	// actors holds the signaling actor of each room with queued work, and pendingPeers
+	// the peers skipped mid-negotiation, by room. Both are guarded by actorsMu.
+	actorsMu     sync.Mutex
+	actors       map[string]*roomActor
+	pendingPeers map[*webrtc.PeerConnection]string
+
+	// subscriptions holds the subscription filter of peers that subscribed or unsubscribed
+	subscriptionsMu sync.Mutex
+	subscriptions   map[*webrtc.PeerConnection]*subscriptionFilter
 }
 
 // NewCoordinator creates a new signaling coordinator
@@ -28,9 +44,44 @@ func NewCoordinator(trackManager *track.Manager, webrtcManager *peerManager.Mana
 		webrtcManager: webrtcManager,
 		roomManager:   roomManager,
 		debug:         debug,
+
+		negotiationLocks: make(map[*webrtc.PeerConnection]*sync.Mutex),
+		actors:           make(map[string]*roomActor),
+		pendingPeers:     make(map[*webrtc.PeerConnection]string),
+		subscriptions:    make(map[*webrtc.PeerConnection]*subscriptionFilter),
 	}
 }
 
+// LockNegotiation locks the offer/answer state of a peer connection and returns the unlock function.
+// SFU offers and answers to client offers must not interleave on the same peer connection.
+func (c *Coordinator) LockNegotiation(peerConnection *webrtc.PeerConnection) func() {
+	c.negotiationMu.Lock()
+	lock, exists := c.negotiationLocks[peerConnection]
+	if !exists {
+		lock = &sync.Mutex{}
+		c.negotiationLocks[peerConnection] = lock
+	}
+	c.negotiationMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// ReleasePeer forgets the negotiation and subscription state of a peer connection that is going away
+func (c *Coordinator) ReleasePeer(peerConnection *webrtc.PeerConnection) {
+	c.negotiationMu.Lock()
+	delete(c.negotiationLocks, peerConnection)
+	c.negotiationMu.Unlock()
+
+	c.actorsMu.Lock()
+	delete(c.pendingPeers, peerConnection)
+	c.actorsMu.Unlock()
+
+	c.subscriptionsMu.Lock()
+	delete(c.subscriptions, peerConnection)
+	c.subscriptionsMu.Unlock()
+}
+
 // debugLog logs debug messages if debug mode is enabled
 func (c *Coordinator) debugLog(format string, args ...interface{}) {
 	if c.debug {
@@ -38,11 +89,35 @@ func (c *Coordinator) debugLog(format string, args ...interface{}) {
 	}
 }
 
-// SignalPeerConnectionsInRoom updates each peer connection in a specific room
+// SignalPeerConnectionsInRoom schedules a renegotiation of every peer connection in a room.
+// Triggers are coalesced by the room's signaling actor, so callers never block on signaling.
 func (c *Coordinator) SignalPeerConnectionsInRoom(roomID string) {
-	recovery.SafeExecuteWithContext("SIGNALING", "SIGNAL_PEERS", "", roomID, "Starting peer signaling", func() error {
-		c.debugLog("🔄 Starting peer connection signaling for room '%s'", roomID)
+	recovery.SafeExecuteWithContext("SIGNALING", "SIGNAL_PEERS", "", roomID, "Scheduling peer signaling", func() error {
+		c.debugLog("🔄 Scheduling peer connection signaling for room '%s'", roomID)
+		c.triggerRoom(roomID, nil)
+		return nil
+	})
+}
 
+// OnSignalingStable should be called when a peer connection returns to the stable signaling state.
+// A peer that was skipped by an earlier sync because it was mid-negotiation is re-offered.
+func (c *Coordinator) OnSignalingStable(peerConnection *webrtc.PeerConnection) {
+	c.actorsMu.Lock()
+	roomID, pending := c.pendingPeers[peerConnection]
+	delete(c.pendingPeers, peerConnection)
+	c.actorsMu.Unlock()
+
+	if pending {
+		c.debugLog("🔁 Skipped peer in room '%s' is stable again, re-offering", roomID)
+		c.triggerRoom(roomID, peerConnection)
+	}
+}
+
+// syncRoom negotiates the peer connections of a room once, one peer at a time.
+// A nil only set syncs every peer, otherwise just the peers in the set.
+// It is only called from the room's signaling actor.
+func (c *Coordinator) syncRoom(roomID string, only map[*webrtc.PeerConnection]bool) error {
+	return recovery.SafeExecuteWithContext("SIGNALING", "SYNC_ATTEMPT", "", roomID, "Synchronizing peers", func() error {
 		// Get all peer connections in the room from room manager
 		peerMap, err := c.roomManager.GetPeersInRoom(roomID)
 		if err != nil {
@@ -64,97 +139,109 @@ func (c *Coordinator) SignalPeerConnectionsInRoom(roomID string) {
 			return nil
 		}
 
-		// Attempt to synchronize peer connections with recovery
-		attemptSync := func() (tryAgain bool) {
-			return recovery.SafeExecuteWithContext("SIGNALING", "SYNC_ATTEMPT", "", roomID, "Synchronizing peers", func() error {
-				// Get room-specific tracks instead of global tracks
-				tracks := c.trackManager.GetTracksInRoom(roomID)
-				c.debugLog("🎵 Available tracks for room '%s': %d", roomID, len(tracks))
-
-				syncSuccess := 0
-				syncErrors := 0
-
-				for clientID, peerConnection := range peerMap {
-					// Validate peer connection with nil check
-					if peerConnection == nil {
-						c.debugLog("⚠️ Nil peer connection for client %s, skipping", clientID)
-						syncErrors++
-						continue
-					}
-
-					// Check if peer connection is still valid before processing
-					connectionState := peerConnection.ConnectionState()
-					if connectionState == webrtc.PeerConnectionStateClosed ||
-						connectionState == webrtc.PeerConnectionStateFailed {
-						c.debugLog("⚠️ Skipping closed/failed peer connection for %s (state: %s)", clientID, connectionState.String())
-						continue
-					}
-
-					c.debugLog("🔄 Synchronizing peer %s in room '%s' (state: %s)", clientID, roomID, connectionState.String())
-
-					// Get the corresponding WebSocket connection with nil check
-					wsConn, exists := connectionMap[clientID]
-					if !exists || wsConn == nil {
-						c.debugLog("❌ No WebSocket connection found for client %s", clientID)
-						syncErrors++
-						continue
-					}
-
-					// Process peer connection with individual recovery
-					peerErr := recovery.SafeExecuteWithContext("SIGNALING", "PROCESS_PEER", clientID, roomID, "Processing individual peer", func() error {
-						return c.processPeerConnection(clientID, peerConnection, wsConn, tracks, roomID)
-					})
-
-					if peerErr != nil {
-						c.debugLog("❌ Error processing peer %s: %v", clientID, peerErr)
-						syncErrors++
-					} else {
-						syncSuccess++
-					}
-				}
+		// Get room-specific tracks instead of global tracks
+		tracks := c.trackManager.GetTracksInRoom(roomID)
+		c.debugLog("🎵 Available tracks for room '%s': %d", roomID, len(tracks))
 
-				c.debugLog("🔄 Sync attempt complete: %d successful, %d errors", syncSuccess, syncErrors)
+		syncSuccess := 0
+		syncPending := 0
+		syncErrors := 0
 
-				if syncErrors > 0 {
-					return recovery.SafeExecute("SIGNALING", "SYNC_ERROR_HANDLING", func() error {
-						// Return error to indicate retry needed, but don't panic
-						return fmt.Errorf("sync had %d errors out of %d peers", syncErrors, len(peerMap))
-					})
-				}
+		for userID, peerConnection := range peerMap {
+			// Validate peer connection with nil check
+			if peerConnection == nil {
+				c.debugLog("⚠️ Nil peer connection for client %s, skipping", userID)
+				syncErrors++
+				continue
+			}
 
-				return nil
-			}) != nil // Convert error to boolean for tryAgain
-		}
+			if only != nil && !only[peerConnection] {
+				continue
+			}
+
+			// Check if peer connection is still valid before processing
+			connectionState := peerConnection.ConnectionState()
+			if connectionState == webrtc.PeerConnectionStateClosed ||
+				connectionState == webrtc.PeerConnectionStateFailed {
+				c.debugLog("⚠️ Skipping closed/failed peer connection for %s (state: %s)", userID, connectionState.String())
+				continue
+			}
+
+			c.debugLog("🔄 Synchronizing peer %s in room '%s' (state: %s)", userID, roomID, connectionState.String())
 
-		// Reduced retry attempts to prevent excessive load during rapid connect/disconnect
-		c.debugLog("🔄 Starting synchronization attempts for room '%s'", roomID)
-		for syncAttempt := 0; syncAttempt < 10; syncAttempt++ { // Reduced from 25 to 10
-			c.debugLog("🔄 Sync attempt %d/10 for room '%s'", syncAttempt+1, roomID)
-			if !attemptSync() {
-				c.debugLog("✅ Synchronization successful for room '%s' after %d attempts", roomID, syncAttempt+1)
-				break
+			// Get the corresponding WebSocket connection with nil check
+			wsConn, exists := connectionMap[userID]
+			if !exists || wsConn == nil {
+				c.debugLog("❌ No WebSocket connection found for client %s", userID)
+				syncErrors++
+				continue
+			}
+
+			// Peers only receive the tracks they subscribed to, and deafened users
+			// must not receive anyone else's audio
+			peerTracks := c.subscribedTracks(peerConnection, tracks)
+			if c.roomManager.GetUserAudioState(roomID, userID).Deafened {
+				c.debugLog("🔇 Peer %s is deafened, withholding audio tracks", userID)
+				peerTracks = withoutAudioTracks(peerTracks)
 			}
-			if syncAttempt == 9 { // Reduced from 24 to 9
-				c.debugLog("⚠️  Max sync attempts reached for room '%s', giving up to prevent server overload", roomID)
-				// Don't schedule retry to prevent cascading failures during rapid connect/disconnect
-				return nil
+
+			// Process peer connection with individual recovery
+			var offered bool
+			peerErr := recovery.SafeExecuteWithContext("SIGNALING", "PROCESS_PEER", userID, roomID, "Processing individual peer", func() error {
+				var processErr error
+				offered, processErr = c.processPeerConnection(userID, peerConnection, wsConn, peerTracks, roomID)
+				return processErr
+			})
+
+			switch {
+			case peerErr != nil:
+				c.debugLog("❌ Error processing peer %s: %v", userID, peerErr)
+				syncErrors++
+			case !offered:
+				// Revisited once the peer's signaling state returns to stable
+				c.markPending(peerConnection, roomID)
+				syncPending++
+			default:
+				c.clearPending(peerConnection)
+				syncSuccess++
 			}
 		}
 
+		c.debugLog("🔄 Sync complete for room '%s': %d offered, %d pending, %d errors", roomID, syncSuccess, syncPending, syncErrors)
+
+		if syncErrors > 0 {
+			return fmt.Errorf("sync had %d errors out of %d peers", syncErrors, len(peerMap))
+		}
+
 		// Dispatch keyframe after successful sync - now room-specific with recovery
 		recovery.SafeExecuteWithContext("SIGNALING", "DISPATCH_KEYFRAME", "", roomID, "Dispatching keyframe", func() error {
 			c.debugLog("🔑 Dispatching keyframe for room '%s'", roomID)
 			c.webrtcManager.DispatchKeyFrameToRoom(roomID)
 			return nil
 		})
-
-		c.debugLog("✅ Peer connection signaling completed for room '%s'", roomID)
 		return nil
 	})
 }
 
-// processPeerConnection handles the signaling for a single peer connection
-func (c *Coordinator) processPeerConnection(clientID string, peerConnection *webrtc.PeerConnection, wsConn interface{}, tracks map[string]*webrtc.TrackLocalStaticRTP, roomID string) error {
+// withoutAudioTracks returns a copy of tracks with all audio tracks removed
+func withoutAudioTracks(tracks map[string]*track.PublishedTrack) map[string]*track.PublishedTrack {
+	filtered := make(map[string]*track.PublishedTrack, len(tracks))
+	for trackID, publishedTrack := range tracks {
+		if publishedTrack != nil && publishedTrack.Kind() == webrtc.RTPCodecTypeAudio {
+			continue
+		}
+		filtered[trackID] = publishedTrack
+	}
+	return filtered
+}
+
+// processPeerConnection handles the signaling for a single peer connection.
+// Every track is sent through a DownTrack of its own for each peer.
+// It reports whether an offer was sent; no offer is sent while the peer is mid-negotiation.
+func (c *Coordinator) processPeerConnection(userID string, peerConnection *webrtc.PeerConnection, wsConn interface{}, tracks map[string]*track.PublishedTrack, roomID string) (bool, error) {
+	unlock := c.LockNegotiation(peerConnection)
+	defer unlock()
+
 	// Map of senders we are already using to avoid duplicates
 	existingSenders := map[string]bool{}
 	senderCount := 0
@@ -168,15 +255,20 @@ func (c *Coordinator) processPeerConnection(clientID string, peerConnection *web
 			}
 
 			senderCount++
-			existingSenders[sender.Track().ID()] = true
-
-			// If a sender's track is not in our list of room tracks, remove it
-			if _, ok := tracks[sender.Track().ID()]; !ok {
-				c.debugLog("🗑️  Removing obsolete sender track %s from peer %s", sender.Track().ID(), clientID)
-				if err := peerConnection.RemoveTrack(sender); err != nil {
-					c.debugLog("❌ Error removing sender track: %v", err)
-					return err
-				}
+			trackID := sender.Track().ID()
+
+			// If a sender's track is not in our list of room tracks, remove it. Subscriptions
+			// to a track that was republished under the same ID are replaced.
+			downTrack, ok := sender.Track().(*track.DownTrack)
+			if ok && downTrack.Source() == tracks[trackID] {
+				existingSenders[trackID] = true
+				continue
+			}
+
+			c.debugLog("🗑️  Removing obsolete sender track %s from peer %s", trackID, userID)
+			if err := peerConnection.RemoveTrack(sender); err != nil {
+				c.debugLog("❌ Error removing sender track: %v", err)
+				return false, err
 			}
 		}
 	}
@@ -194,64 +286,70 @@ func (c *Coordinator) processPeerConnection(clientID string, peerConnection *web
 		}
 	}
 
-	c.debugLog("🔗 Peer %s has %d senders, %d receivers", clientID, senderCount, receiverCount)
+	c.debugLog("🔗 Peer %s has %d senders, %d receivers", userID, senderCount, receiverCount)
 
-	// Add any missing local tracks to the peer connection
+	// Subscribe the peer to any missing room tracks
 	tracksAdded := 0
-	for trackID, localTrack := range tracks {
-		if localTrack == nil {
+	for trackID, publishedTrack := range tracks {
+		if publishedTrack == nil {
 			c.debugLog("⚠️ Nil track found for ID %s, skipping", trackID)
 			continue
 		}
 
 		if _, ok := existingSenders[trackID]; !ok {
-			c.debugLog("➕ Adding track %s to peer %s", trackID, clientID)
-			if _, err := peerConnection.AddTrack(localTrack); err != nil {
+			c.debugLog("➕ Adding track %s to peer %s", trackID, userID)
+			downTrack := publishedTrack.Subscribe(userID, func() int {
+				return c.webrtcManager.EstimatedBitrate(peerConnection)
+			})
+			sender, err := peerConnection.AddTrack(downTrack)
+			if err != nil {
 				c.debugLog("❌ Error adding track to peer connection: %v", err)
-				return err
+				publishedTrack.Unsubscribe(downTrack)
+				return false, err
 			}
+			c.readSenderRTCP(sender, downTrack.RequestKeyFrame)
 			tracksAdded++
 			c.debugLog("✅ Added track to peer connection in room %s: ID=%s", roomID, trackID)
 		}
 	}
 
 	if tracksAdded > 0 {
-		c.debugLog("➕ Added %d tracks to peer %s", tracksAdded, clientID)
+		c.debugLog("➕ Added %d tracks to peer %s", tracksAdded, userID)
 	}
 
 	// Check if the signaling state allows for creating a new offer
 	signalingState := peerConnection.SignalingState()
-	c.debugLog("🔗 Peer %s signaling state: %s", clientID, signalingState.String())
+	c.debugLog("🔗 Peer %s signaling state: %s", userID, signalingState.String())
 
 	if signalingState != webrtc.SignalingStateStable {
-		c.debugLog("⏳ Cannot create offer for %s, signaling state: %v", clientID, signalingState)
-		return nil // Not an error, just can't create offer right now
+		c.debugLog("⏳ Cannot create offer for %s, signaling state: %v", userID, signalingState)
+		return false, nil // Not an error, just can't create offer right now
 	}
 
 	// Create and send an offer to the peer to update the connection state
-	c.debugLog("📤 Creating offer for peer %s", clientID)
+	c.debugLog("📤 Creating offer for peer %s", userID)
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
-		c.debugLog("❌ Error creating offer for %s: %v", clientID, err)
-		return err
+		c.debugLog("❌ Error creating offer for %s: %v", userID, err)
+		return false, err
 	}
 
 	if err = peerConnection.SetLocalDescription(offer); err != nil {
-		c.debugLog("❌ Error setting local description for %s: %v", clientID, err)
-		return err
+		c.debugLog("❌ Error setting local description for %s: %v", userID, err)
+		return false, err
 	}
 
 	// Safe JSON marshaling
 	offerString, err := recovery.SafeJSONMarshal(offer)
 	if err != nil {
-		c.debugLog("❌ Error marshalling offer for %s: %v", clientID, err)
-		return err
+		c.debugLog("❌ Error marshalling offer for %s: %v", userID, err)
+		return false, err
 	}
 
-	c.debugLog("📤 Sending offer to peer %s (%d bytes)", clientID, len(offerString))
+	c.debugLog("📤 Sending offer to peer %s (%d bytes)", userID, len(offerString))
 
 	// Send message with type assertion and nil check
-	return recovery.SafeExecuteWithContext("SIGNALING", "SEND_OFFER", clientID, roomID, "Sending WebRTC offer", func() error {
+	return true, recovery.SafeExecuteWithContext("SIGNALING", "SEND_OFFER", userID, roomID, "Sending WebRTC offer", func() error {
 		// Type assert the WebSocket connection
 		if conn, ok := wsConn.(interface{ WriteJSON(interface{}) error }); ok && conn != nil {
 			return conn.WriteJSON(&types.WebSocketMessage{
@@ -259,7 +357,27 @@ func (c *Coordinator) processPeerConnection(clientID string, peerConnection *web
 				Data:  string(offerString),
 			})
 		}
-		return fmt.Errorf("invalid WebSocket connection type for client %s", clientID)
+		return fmt.Errorf("invalid WebSocket connection type for client %s", userID)
+	})
+}
+
+// readSenderRTCP drains the RTCP a peer sends back for one of its senders, which lets the
+// interceptors act on NACKs and congestion control feedback. Keyframe requests are passed
+// to onKeyFrameRequest.
+func (c *Coordinator) readSenderRTCP(sender *webrtc.RTPSender, onKeyFrameRequest func()) {
+	recovery.SafeGoroutine("SIGNALING", "READ_SENDER_RTCP", func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				switch packet.(type) {
+				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+					onKeyFrameRequest()
+				}
+			}
+		}
 	})
 }
 
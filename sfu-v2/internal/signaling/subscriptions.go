@@ -0,0 +1,131 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/internal/track"
+)
+
+// maxSubscriptionRules caps the user and track rules a peer can hold
+const maxSubscriptionRules = 256
+
+// ErrSubscriptionLimit is returned when a subscription update would exceed maxSubscriptionRules
+var ErrSubscriptionLimit = errors.New("subscription rule limit reached")
+
+// subscriptionFilter holds the tracks a peer chose to receive. A rule naming a track takes
+// precedence over a rule naming its publisher; tracks without a rule follow receiveAll.
+type subscriptionFilter struct {
+	receiveAll bool
+	users      map[string]bool
+	tracks     map[string]bool
+}
+
+// newSubscriptionFilter creates a filter without rules that receives everything or nothing
+func newSubscriptionFilter(receiveAll bool) *subscriptionFilter {
+	return &subscriptionFilter{
+		receiveAll: receiveAll,
+		users:      make(map[string]bool),
+		tracks:     make(map[string]bool),
+	}
+}
+
+// wants reports whether the peer receives a track published by a user
+func (f *subscriptionFilter) wants(trackID, userID string) bool {
+	if subscribed, exists := f.tracks[trackID]; exists {
+		return subscribed
+	}
+	if subscribed, exists := f.users[userID]; exists {
+		return subscribed
+	}
+	return f.receiveAll
+}
+
+// UpdateSubscriptions subscribes a peer to, or unsubscribes it from, the tracks of the given
+// users and the given tracks. Naming neither applies to every track and clears earlier rules.
+// Updates that would leave the peer with more than maxSubscriptionRules rules are rejected.
+// Only the peer's own connection is renegotiated, and only if its rules changed.
+func (c *Coordinator) UpdateSubscriptions(peerConnection *webrtc.PeerConnection, roomID string, subscribe bool, userIDs, trackIDs []string) error {
+	c.subscriptionsMu.Lock()
+	filter, exists := c.subscriptions[peerConnection]
+	if len(userIDs) == 0 && len(trackIDs) == 0 {
+		changed := !exists || filter.receiveAll != subscribe || len(filter.users) > 0 || len(filter.tracks) > 0
+		c.subscriptions[peerConnection] = newSubscriptionFilter(subscribe)
+		c.subscriptionsMu.Unlock()
+
+		if changed {
+			c.triggerRoom(roomID, peerConnection)
+		}
+		return nil
+	}
+	if !exists {
+		// Peers receive every track until they unsubscribe
+		filter = newSubscriptionFilter(true)
+	}
+
+	if rules := filter.countRulesAfter(userIDs, trackIDs); rules > maxSubscriptionRules {
+		c.subscriptionsMu.Unlock()
+		return fmt.Errorf("%w: %d rules, at most %d are allowed", ErrSubscriptionLimit, rules, maxSubscriptionRules)
+	}
+
+	changed := false
+	for _, userID := range userIDs {
+		if current, exists := filter.users[userID]; !exists || current != subscribe {
+			filter.users[userID] = subscribe
+			changed = true
+		}
+	}
+	for _, trackID := range trackIDs {
+		if current, exists := filter.tracks[trackID]; !exists || current != subscribe {
+			filter.tracks[trackID] = subscribe
+			changed = true
+		}
+	}
+	c.subscriptions[peerConnection] = filter
+	c.subscriptionsMu.Unlock()
+
+	if changed {
+		c.triggerRoom(roomID, peerConnection)
+	}
+	return nil
+}
+
+// countRulesAfter returns the number of rules the filter holds once the given IDs have rules
+func (f *subscriptionFilter) countRulesAfter(userIDs, trackIDs []string) int {
+	rules := len(f.users) + len(f.tracks)
+	added := make(map[string]bool, len(userIDs)+len(trackIDs))
+	for _, userID := range userIDs {
+		if _, exists := f.users[userID]; !exists && !added["user:"+userID] {
+			added["user:"+userID] = true
+			rules++
+		}
+	}
+	for _, trackID := range trackIDs {
+		if _, exists := f.tracks[trackID]; !exists && !added["track:"+trackID] {
+			added["track:"+trackID] = true
+			rules++
+		}
+	}
+	return rules
+}
+
+// subscribedTracks returns the tracks a peer chose to receive
+func (c *Coordinator) subscribedTracks(peerConnection *webrtc.PeerConnection, tracks map[string]*track.PublishedTrack) map[string]*track.PublishedTrack {
+	c.subscriptionsMu.Lock()
+	defer c.subscriptionsMu.Unlock()
+
+	filter, exists := c.subscriptions[peerConnection]
+	if !exists {
+		return tracks
+	}
+
+	subscribed := make(map[string]*track.PublishedTrack, len(tracks))
+	for trackID, publishedTrack := range tracks {
+		if publishedTrack != nil && filter.wants(publishedTrack.ID(), publishedTrack.StreamID()) {
+			subscribed[trackID] = publishedTrack
+		}
+	}
+	return subscribed
+}
@@ -0,0 +1,126 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestSubscriptionFilterWants(t *testing.T) {
+	tests := []struct {
+		name       string
+		receiveAll bool
+		users      map[string]bool
+		tracks     map[string]bool
+		want       bool
+	}{
+		{"receive all without rules", true, nil, nil, true},
+		{"receive nothing without rules", false, nil, nil, false},
+		{"user unsubscribed", true, map[string]bool{"alice": false}, nil, false},
+		{"user subscribed", false, map[string]bool{"alice": true}, nil, true},
+		{"other user's rule", false, map[string]bool{"bob": true}, nil, false},
+		{"track subscribed", false, nil, map[string]bool{"camera": true}, true},
+		{"track rule overrides user rule", true, map[string]bool{"alice": false}, map[string]bool{"camera": true}, true},
+		{"track unsubscribed despite user rule", false, map[string]bool{"alice": true}, map[string]bool{"camera": false}, false},
+		{"other track's rule", true, map[string]bool{"alice": false}, map[string]bool{"screen": true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := newSubscriptionFilter(tt.receiveAll)
+			for userID, subscribed := range tt.users {
+				filter.users[userID] = subscribed
+			}
+			for trackID, subscribed := range tt.tracks {
+				filter.tracks[trackID] = subscribed
+			}
+
+			if got := filter.wants("camera", "alice"); got != tt.want {
+				t.Errorf("wants(camera, alice) = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSubscriptionsLimit(t *testing.T) {
+	ids := func(prefix string, n int) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = fmt.Sprintf("%s-%d", prefix, i)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		userIDs  []string
+		trackIDs []string
+		wantErr  bool
+	}{
+		{"at the limit", ids("user", maxSubscriptionRules/2), ids("track", maxSubscriptionRules/2), false},
+		{"over the limit", ids("user", maxSubscriptionRules/2), ids("track", maxSubscriptionRules/2+1), true},
+		{"duplicates count once", append(ids("user", maxSubscriptionRules), ids("user", maxSubscriptionRules)...), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Coordinator{
+				// A registered actor is woken instead of started, so no sync runs
+				actors:        map[string]*roomActor{"room": {roomID: "room", wake: make(chan struct{}, 1), peers: make(map[*webrtc.PeerConnection]bool)}},
+				subscriptions: make(map[*webrtc.PeerConnection]*subscriptionFilter),
+			}
+			pc := &webrtc.PeerConnection{}
+
+			err := c.UpdateSubscriptions(pc, "room", false, tt.userIDs, tt.trackIDs)
+			if tt.wantErr {
+				if !errors.Is(err, ErrSubscriptionLimit) {
+					t.Fatalf("got error %v, want %v", err, ErrSubscriptionLimit)
+				}
+				if _, exists := c.subscriptions[pc]; exists {
+					t.Error("rejected update was stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateSubscriptionsRenegotiatesOnChange(t *testing.T) {
+	pc := &webrtc.PeerConnection{}
+	actor := &roomActor{roomID: "room", wake: make(chan struct{}, 1), peers: make(map[*webrtc.PeerConnection]bool)}
+	c := &Coordinator{
+		actors:        map[string]*roomActor{"room": actor},
+		subscriptions: make(map[*webrtc.PeerConnection]*subscriptionFilter),
+	}
+
+	steps := []struct {
+		name      string
+		subscribe bool
+		userIDs   []string
+		want      bool
+	}{
+		{"first rule", false, []string{"alice"}, true},
+		{"same rule again", false, []string{"alice"}, false},
+		{"rule flipped", true, []string{"alice"}, true},
+		{"receive all", true, nil, true},
+		{"receive all again", true, nil, false},
+	}
+
+	for _, step := range steps {
+		if err := c.UpdateSubscriptions(pc, "room", step.subscribe, step.userIDs, nil); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := actor.take()[pc]; got != step.want {
+			t.Errorf("%s: renegotiated = %t, want %t", step.name, got, step.want)
+		}
+		select {
+		case <-actor.wake:
+		default:
+		}
+	}
+}
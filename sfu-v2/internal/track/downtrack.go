@@ -0,0 +1,329 @@
+package track
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/pkg/types"
+)
+
+// DownTrack is the local track one subscriber receives a published track through. It forwards
+// one layer at a time and can be paused for this subscriber alone. Sequence numbers and
+// timestamps are rewritten so pauses and layer switches look like one continuous stream.
+type DownTrack struct {
+	source    *PublishedTrack
+	userID    string
+	bandwidth func() int
+
+	mu          sync.Mutex
+	ssrc        webrtc.SSRC
+	payloadType webrtc.PayloadType
+	writeStream webrtc.TrackLocalWriter
+	paused      bool
+	// maxQuality is the highest simulcast quality the subscriber asked for
+	maxQuality string
+
+	// currentRID is the layer being forwarded while forwarding is set. While switching is set,
+	// the DownTrack moves to targetRID on that layer's next keyframe.
+	currentRID string
+	forwarding bool
+	targetRID  string
+	switching  bool
+
+	// Rewriting state: the offsets are subtracted from the source's sequence numbers and
+	// timestamps, and recomputed on the first packet after a gap when resync is set
+	started   bool
+	resync    bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+	lastWrite time.Time
+}
+
+// newDownTrack creates an unbound DownTrack of a published track for a subscriber
+func newDownTrack(source *PublishedTrack, userID string, bandwidth func() int) *DownTrack {
+	return &DownTrack{
+		source:     source,
+		userID:     userID,
+		bandwidth:  bandwidth,
+		maxQuality: types.VideoQualityHigh,
+	}
+}
+
+// Bind is called by the peer connection once the subscriber's sender has negotiated a codec
+func (d *DownTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := matchCodec(d.source.codec, ctx.CodecParameters())
+	if err != nil {
+		return webrtc.RTPCodecParameters{}, err
+	}
+
+	d.mu.Lock()
+	d.ssrc = ctx.SSRC()
+	d.payloadType = codec.PayloadType
+	d.writeStream = ctx.WriteStream()
+	d.mu.Unlock()
+
+	// Video can only start forwarding on a keyframe
+	if d.source.kind == webrtc.RTPCodecTypeVideo {
+		d.RequestKeyFrame()
+	}
+	return codec, nil
+}
+
+// Unbind is called when the subscriber's sender stops, which ends the subscription
+func (d *DownTrack) Unbind(webrtc.TrackLocalContext) error {
+	d.mu.Lock()
+	d.writeStream = nil
+	d.mu.Unlock()
+
+	d.source.Unsubscribe(d)
+	return nil
+}
+
+// ID returns the ID of the published track
+func (d *DownTrack) ID() string {
+	return d.source.id
+}
+
+// RID returns an empty RID, subscribers receive a single layer
+func (d *DownTrack) RID() string {
+	return ""
+}
+
+// StreamID returns the stream ID of the published track
+func (d *DownTrack) StreamID() string {
+	return d.source.streamID
+}
+
+// Kind returns whether the published track is audio or video
+func (d *DownTrack) Kind() webrtc.RTPCodecType {
+	return d.source.kind
+}
+
+// Source returns the published track the DownTrack forwards
+func (d *DownTrack) Source() *PublishedTrack {
+	return d.source
+}
+
+// Pause stops forwarding to this subscriber
+func (d *DownTrack) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = true
+}
+
+// Resume restarts forwarding after Pause. Video resumes on the next keyframe.
+func (d *DownTrack) Resume() {
+	d.mu.Lock()
+	if !d.paused {
+		d.mu.Unlock()
+		return
+	}
+	d.paused = false
+	d.resync = true
+
+	isVideo := d.source.kind == webrtc.RTPCodecTypeVideo
+	if isVideo && d.forwarding {
+		d.targetRID = d.currentRID
+		d.switching = true
+		d.forwarding = false
+	}
+	d.mu.Unlock()
+
+	if isVideo {
+		d.RequestKeyFrame()
+	}
+}
+
+// Paused reports whether forwarding to this subscriber is paused
+func (d *DownTrack) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// RequestKeyFrame asks the publisher for a keyframe on the layer the subscriber receives
+func (d *DownTrack) RequestKeyFrame() {
+	d.mu.Lock()
+	rid := d.currentRID
+	if d.switching {
+		rid = d.targetRID
+	}
+	d.mu.Unlock()
+
+	d.source.requestLayerKeyFrame(rid)
+}
+
+// setMaxQuality caps the simulcast layers the subscriber receives
+func (d *DownTrack) setMaxQuality(quality string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxQuality = quality
+}
+
+// switchTo makes the DownTrack move to a layer on that layer's next keyframe
+func (d *DownTrack) switchTo(rid string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.targetRID = rid
+	d.switching = true
+}
+
+// resyncNext makes the next forwarded packet continue the rewritten stream across a gap
+func (d *DownTrack) resyncNext() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resync = true
+}
+
+// selectLayer picks the highest layer within the subscriber's requested quality and bandwidth
+// budget, zero meaning no budget. It returns the SSRC of the new target layer when the
+// subscriber has to switch, so a keyframe can be requested.
+func (d *DownTrack) selectLayer(layers []layerInfo, budget int) (webrtc.SSRC, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := -1
+	for i, layer := range layers {
+		if d.forwarding && layer.rid == d.currentRID {
+			current = i
+		}
+	}
+
+	selected := 0
+	for i := 1; i <= maxLayerIndex(d.maxQuality, len(layers)); i++ {
+		required := layers[i].bitrate
+		if i > current {
+			required += required * upgradeHeadroom / 100
+		}
+		if budget > 0 && required > budget {
+			break
+		}
+		selected = i
+	}
+
+	target := layers[selected]
+	if d.forwarding && target.rid == d.currentRID {
+		// Staying on the current layer cancels a pending switch
+		d.switching = false
+		return 0, false
+	}
+	if d.switching && target.rid == d.targetRID {
+		return 0, false
+	}
+
+	d.targetRID = target.rid
+	d.switching = true
+	return target.ssrc, true
+}
+
+// maxLayerIndex maps a requested quality to the highest layer index it allows
+func maxLayerIndex(quality string, layerCount int) int {
+	switch quality {
+	case types.VideoQualityLow:
+		return 0
+	case types.VideoQualityMedium:
+		return (layerCount - 1) / 2
+	default:
+		return layerCount - 1
+	}
+}
+
+// writeRTP forwards a packet if it belongs to the subscriber's layer. A keyframe of the
+// target layer switches the subscriber to that layer. The packet is written to the network
+// after d.mu is released, so a slow write does not block the subscriber's other calls.
+func (d *DownTrack) writeRTP(rid string, packet *rtp.Packet, keyFrame bool) {
+	header, writeStream, ok := d.rewriteHeader(rid, packet, keyFrame)
+	if !ok {
+		return
+	}
+	writeStream.WriteRTP(&header, packet.Payload)
+}
+
+// rewriteHeader returns the header to forward a packet with and the stream to write it to,
+// or false if the packet is not forwarded to this subscriber
+func (d *DownTrack) rewriteHeader(rid string, packet *rtp.Packet, keyFrame bool) (rtp.Header, webrtc.TrackLocalWriter, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.writeStream == nil || d.paused {
+		return rtp.Header{}, nil, false
+	}
+
+	if d.switching && rid == d.targetRID && keyFrame {
+		d.currentRID = rid
+		d.forwarding = true
+		d.switching = false
+		d.resync = true
+	}
+	if !d.forwarding || rid != d.currentRID {
+		return rtp.Header{}, nil, false
+	}
+
+	if d.resync {
+		d.resyncLocked(packet)
+	}
+
+	header := packet.Header
+	header.SSRC = uint32(d.ssrc)
+	header.PayloadType = uint8(d.payloadType)
+	header.SequenceNumber = packet.SequenceNumber - d.seqOffset
+	header.Timestamp = packet.Timestamp - d.tsOffset
+	// Header extension IDs were negotiated with the publisher, not with this subscriber
+	header.Extension = false
+	header.ExtensionProfile = 0
+	header.Extensions = nil
+
+	if !d.started || int16(header.SequenceNumber-d.lastSeq) > 0 {
+		d.lastSeq = header.SequenceNumber
+	}
+	if !d.started || int32(header.Timestamp-d.lastTS) > 0 {
+		d.lastTS = header.Timestamp
+	}
+	d.started = true
+	d.lastWrite = time.Now()
+
+	return header, d.writeStream, true
+}
+
+// resyncLocked recomputes the offsets so packet directly follows the last forwarded packet,
+// advancing the timestamp by the wall clock time in between. The caller must hold d.mu.
+func (d *DownTrack) resyncLocked(packet *rtp.Packet) {
+	d.resync = false
+	if !d.started {
+		return
+	}
+
+	elapsed := uint32(time.Since(d.lastWrite).Seconds() * float64(d.source.codec.ClockRate))
+	if elapsed == 0 {
+		elapsed = 1
+	}
+	d.seqOffset = packet.SequenceNumber - d.lastSeq - 1
+	d.tsOffset = packet.Timestamp - (d.lastTS + elapsed)
+}
+
+// matchCodec finds the negotiated codec parameters for a codec, preferring an exact fmtp match
+func matchCodec(codec webrtc.RTPCodecCapability, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, error) {
+	var partial *webrtc.RTPCodecParameters
+	for i, parameters := range negotiated {
+		if !strings.EqualFold(parameters.MimeType, codec.MimeType) {
+			continue
+		}
+		if parameters.SDPFmtpLine == codec.SDPFmtpLine {
+			return parameters, nil
+		}
+		if partial == nil {
+			partial = &negotiated[i]
+		}
+	}
+
+	if partial == nil {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+	return *partial, nil
+}
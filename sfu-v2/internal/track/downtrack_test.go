@@ -0,0 +1,248 @@
+package track
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// recordingWriter records the headers a DownTrack writes
+type recordingWriter struct {
+	headers []rtp.Header
+}
+
+func (w *recordingWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	w.headers = append(w.headers, *header)
+	return len(payload), nil
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// newTestTrack creates a published track with the given layers, the first one being the lowest
+func newTestTrack(kind webrtc.RTPCodecType, codec webrtc.RTPCodecCapability, rids ...string) *PublishedTrack {
+	publishedTrack := &PublishedTrack{
+		id:              "track",
+		streamID:        "alice",
+		kind:            kind,
+		codec:           codec,
+		simulcast:       rids[0] != "",
+		requestKeyFrame: func(webrtc.SSRC) {},
+		layers:          make(map[string]*trackLayer),
+		subscribers:     make(map[string]*DownTrack),
+	}
+	for i, rid := range rids {
+		publishedTrack.addLayer(rid, webrtc.SSRC(i+1))
+		publishedTrack.layers[rid].bitrate = (i + 1) * 100_000
+	}
+	return publishedTrack
+}
+
+var (
+	opus = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	vp8  = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+
+	vp8Key   = []byte{0x10, 0x00}
+	vp8Delta = []byte{0x10, 0x01}
+)
+
+// downTrackStep is a packet from the publisher, or an action on the subscriber when action is set
+type downTrackStep struct {
+	action  string
+	rid     string
+	seq     uint16
+	ts      uint32
+	payload []byte
+}
+
+// forwarded is a packet the subscriber is expected to receive. Packets after a gap get a new
+// timestamp from the wall clock, other packets keep the publisher's timestamp spacing.
+type forwarded struct {
+	seq      uint16
+	afterGap bool
+}
+
+func TestDownTrackContinuity(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  webrtc.RTPCodecType
+		codec webrtc.RTPCodecCapability
+		rids  []string
+		steps []downTrackStep
+		want  []forwarded
+	}{
+		{
+			name:  "audio forwarded unchanged",
+			kind:  webrtc.RTPCodecTypeAudio,
+			codec: opus,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 100, ts: 0}, {seq: 101, ts: 960}, {seq: 102, ts: 1920},
+			},
+			want: []forwarded{{100, false}, {101, false}, {102, false}},
+		},
+		{
+			name:  "sequence number wraparound",
+			kind:  webrtc.RTPCodecTypeAudio,
+			codec: opus,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 65534, ts: 0}, {seq: 65535, ts: 960}, {seq: 0, ts: 1920},
+			},
+			want: []forwarded{{65534, false}, {65535, false}, {0, false}},
+		},
+		{
+			name:  "audio pause and resume",
+			kind:  webrtc.RTPCodecTypeAudio,
+			codec: opus,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 100, ts: 0}, {seq: 101, ts: 960},
+				{action: "pause"},
+				{seq: 102, ts: 1920}, {seq: 103, ts: 2880},
+				{action: "resume"},
+				{seq: 104, ts: 3840}, {seq: 105, ts: 4800},
+			},
+			want: []forwarded{{100, false}, {101, false}, {102, true}, {103, false}},
+		},
+		{
+			name:  "resync across packets dropped upstream",
+			kind:  webrtc.RTPCodecTypeAudio,
+			codec: opus,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 100, ts: 0}, {seq: 101, ts: 960},
+				{action: "resync"},
+				{seq: 150, ts: 48960}, {seq: 151, ts: 49920},
+			},
+			want: []forwarded{{100, false}, {101, false}, {102, true}, {103, false}},
+		},
+		{
+			name:  "reordered packet does not rewind the stream",
+			kind:  webrtc.RTPCodecTypeAudio,
+			codec: opus,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 100, ts: 0}, {seq: 102, ts: 1920}, {seq: 101, ts: 960},
+				{action: "pause"},
+				{action: "resume"},
+				{seq: 110, ts: 9600},
+			},
+			want: []forwarded{{100, false}, {102, false}, {101, false}, {103, true}},
+		},
+		{
+			name:  "video resumes on a keyframe",
+			kind:  webrtc.RTPCodecTypeVideo,
+			codec: vp8,
+			rids:  []string{""},
+			steps: []downTrackStep{
+				{seq: 1, ts: 3000, payload: vp8Key}, {seq: 2, ts: 6000, payload: vp8Delta},
+				{action: "pause"},
+				{seq: 3, ts: 9000, payload: vp8Delta},
+				{action: "resume"},
+				{seq: 4, ts: 12000, payload: vp8Delta},
+				{seq: 5, ts: 15000, payload: vp8Key}, {seq: 6, ts: 18000, payload: vp8Delta},
+			},
+			want: []forwarded{{1, false}, {2, false}, {3, true}, {4, false}},
+		},
+		{
+			name:  "simulcast layer switch",
+			kind:  webrtc.RTPCodecTypeVideo,
+			codec: vp8,
+			rids:  []string{"q", "f"},
+			steps: []downTrackStep{
+				{rid: "q", seq: 10, ts: 9000, payload: vp8Key},
+				{rid: "f", seq: 500, ts: 700000, payload: vp8Delta},
+				{rid: "q", seq: 11, ts: 12000, payload: vp8Delta},
+				{action: "switch", rid: "f"},
+				{rid: "q", seq: 12, ts: 15000, payload: vp8Delta},
+				{rid: "f", seq: 501, ts: 703000, payload: vp8Delta},
+				{rid: "f", seq: 502, ts: 706000, payload: vp8Key},
+				{rid: "q", seq: 13, ts: 18000, payload: vp8Delta},
+				{rid: "f", seq: 503, ts: 709000, payload: vp8Delta},
+			},
+			want: []forwarded{{10, false}, {11, false}, {12, false}, {13, true}, {14, false}},
+		},
+		{
+			name:  "switch back to a lower layer",
+			kind:  webrtc.RTPCodecTypeVideo,
+			codec: vp8,
+			rids:  []string{"q", "f"},
+			steps: []downTrackStep{
+				{rid: "q", seq: 10, ts: 9000, payload: vp8Key},
+				{action: "switch", rid: "f"},
+				{rid: "f", seq: 500, ts: 700000, payload: vp8Key},
+				{rid: "f", seq: 501, ts: 703000, payload: vp8Delta},
+				{action: "switch", rid: "q"},
+				{rid: "q", seq: 11, ts: 12000, payload: vp8Delta},
+				{rid: "f", seq: 502, ts: 706000, payload: vp8Delta},
+				{rid: "q", seq: 12, ts: 15000, payload: vp8Key},
+				{rid: "q", seq: 13, ts: 18000, payload: vp8Delta},
+			},
+			want: []forwarded{{10, false}, {11, true}, {12, false}, {13, false}, {14, true}, {15, false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publishedTrack := newTestTrack(tt.kind, tt.codec, tt.rids...)
+			downTrack := publishedTrack.Subscribe("bob", func() int { return 0 })
+			writer := &recordingWriter{}
+			downTrack.writeStream = writer
+			downTrack.ssrc = 1234
+			downTrack.payloadType = 111
+
+			// Source timestamps of the forwarded packets, to compare their spacing
+			var sourceTS []uint32
+			for _, step := range tt.steps {
+				switch step.action {
+				case "pause":
+					downTrack.Pause()
+				case "resume":
+					downTrack.Resume()
+				case "resync":
+					publishedTrack.Resync()
+				case "switch":
+					downTrack.switchTo(step.rid)
+				default:
+					written := len(writer.headers)
+					publishedTrack.WriteRTP(step.rid, &rtp.Packet{
+						Header:  rtp.Header{SequenceNumber: step.seq, Timestamp: step.ts, SSRC: 99, PayloadType: 96},
+						Payload: step.payload,
+					})
+					if len(writer.headers) > written {
+						sourceTS = append(sourceTS, step.ts)
+					}
+				}
+			}
+
+			if len(writer.headers) != len(tt.want) {
+				t.Fatalf("forwarded %d packets, want %d", len(writer.headers), len(tt.want))
+			}
+			for i, header := range writer.headers {
+				want := tt.want[i]
+				if header.SequenceNumber != want.seq {
+					t.Errorf("packet %d: sequence number %d, want %d", i, header.SequenceNumber, want.seq)
+				}
+				if header.SSRC != 1234 || header.PayloadType != 111 {
+					t.Errorf("packet %d: SSRC %d and payload type %d not rewritten", i, header.SSRC, header.PayloadType)
+				}
+				if i == 0 {
+					continue
+				}
+
+				delta := header.Timestamp - writer.headers[i-1].Timestamp
+				if want.afterGap {
+					// The gap is bridged by the wall clock time, which is short in a test
+					if int32(delta) <= 0 || delta > tt.codec.ClockRate/10 {
+						t.Errorf("packet %d: timestamp advanced by %d across the gap", i, delta)
+					}
+				} else if sourceDelta := sourceTS[i] - sourceTS[i-1]; delta != sourceDelta {
+					t.Errorf("packet %d: timestamp advanced by %d, want %d", i, delta, sourceDelta)
+				}
+			}
+		})
+	}
+}
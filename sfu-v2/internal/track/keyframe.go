@@ -0,0 +1,132 @@
+package track
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// H.264 NAL unit types used to find keyframes
+const (
+	h264NALUTypeIDR   = 5
+	h264NALUTypeSPS   = 7
+	h264NALUTypeSTAPA = 24
+	h264NALUTypeFUA   = 28
+)
+
+// IsKeyFrame reports whether an RTP payload starts a keyframe of the given video codec.
+// Codecs it cannot parse never report keyframes.
+func IsKeyFrame(mimeType string, payload []byte) bool {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP8):
+		return isVP8KeyFrame(payload)
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP9):
+		return isVP9KeyFrame(payload)
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		return isH264KeyFrame(payload)
+	case strings.EqualFold(mimeType, webrtc.MimeTypeAV1):
+		return isAV1KeyFrame(payload)
+	default:
+		return false
+	}
+}
+
+// isVP8KeyFrame parses the VP8 payload descriptor (RFC 7741) and checks the frame tag
+func isVP8KeyFrame(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	// Only the first packet of partition 0 carries the frame tag
+	start := payload[0]&0x10 != 0
+	partitionID := payload[0] & 0x07
+	if !start || partitionID != 0 {
+		return false
+	}
+
+	offset := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return false
+		}
+		extension := payload[1]
+		offset++
+		if extension&0x80 != 0 {
+			// Picture ID, two bytes when the M bit is set
+			if len(payload) <= offset {
+				return false
+			}
+			if payload[offset]&0x80 != 0 {
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+		if extension&0x40 != 0 {
+			offset++ // TL0PICIDX
+		}
+		if extension&0x30 != 0 {
+			offset++ // TID/KEYIDX
+		}
+	}
+
+	if len(payload) <= offset {
+		return false
+	}
+	// The P bit of the frame tag is zero for keyframes
+	return payload[offset]&0x01 == 0
+}
+
+// isVP9KeyFrame checks the VP9 payload descriptor for the start of a non inter-predicted frame
+func isVP9KeyFrame(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	interPredicted := payload[0]&0x40 != 0
+	startOfFrame := payload[0]&0x08 != 0
+	return !interPredicted && startOfFrame
+}
+
+// isH264KeyFrame looks for an IDR slice or SPS in single, STAP-A and FU-A packets
+func isH264KeyFrame(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	switch naluType := payload[0] & 0x1F; naluType {
+	case h264NALUTypeIDR, h264NALUTypeSPS:
+		return true
+	case h264NALUTypeSTAPA:
+		offset := 1
+		for offset+2 < len(payload) {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if offset >= len(payload) {
+				return false
+			}
+			if t := payload[offset] & 0x1F; t == h264NALUTypeIDR || t == h264NALUTypeSPS {
+				return true
+			}
+			offset += size
+		}
+		return false
+	case h264NALUTypeFUA:
+		if len(payload) < 2 {
+			return false
+		}
+		start := payload[1]&0x80 != 0
+		t := payload[1] & 0x1F
+		return start && (t == h264NALUTypeIDR || t == h264NALUTypeSPS)
+	default:
+		return false
+	}
+}
+
+// isAV1KeyFrame checks the N bit of the AV1 aggregation header, set on the first packet
+// of a new coded video sequence
+func isAV1KeyFrame(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	return payload[0]&0x08 != 0
+}
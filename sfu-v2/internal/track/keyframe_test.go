@@ -0,0 +1,54 @@
+package track
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestIsKeyFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		want     bool
+	}{
+		{"VP8 keyframe", webrtc.MimeTypeVP8, []byte{0x10, 0x00}, true},
+		{"VP8 interframe", webrtc.MimeTypeVP8, []byte{0x10, 0x01}, false},
+		{"VP8 continuation packet", webrtc.MimeTypeVP8, []byte{0x00, 0x00}, false},
+		{"VP8 later partition", webrtc.MimeTypeVP8, []byte{0x11, 0x00}, false},
+		{"VP8 keyframe with 15-bit picture ID", webrtc.MimeTypeVP8, []byte{0x90, 0x80, 0x80, 0x01, 0x00}, true},
+		{"VP8 interframe with 7-bit picture ID", webrtc.MimeTypeVP8, []byte{0x90, 0x80, 0x01, 0x01}, false},
+		{"VP8 keyframe with TL0PICIDX and TID", webrtc.MimeTypeVP8, []byte{0x90, 0x60, 0x05, 0x20, 0x00}, true},
+		{"VP8 truncated descriptor", webrtc.MimeTypeVP8, []byte{0x90, 0x80}, false},
+		{"VP8 lowercase MIME type", "video/vp8", []byte{0x10, 0x00}, true},
+
+		{"VP9 keyframe", webrtc.MimeTypeVP9, []byte{0x08}, true},
+		{"VP9 interframe", webrtc.MimeTypeVP9, []byte{0x48}, false},
+		{"VP9 keyframe continuation", webrtc.MimeTypeVP9, []byte{0x00}, false},
+
+		{"H264 IDR", webrtc.MimeTypeH264, []byte{0x65}, true},
+		{"H264 SPS", webrtc.MimeTypeH264, []byte{0x67}, true},
+		{"H264 non-IDR slice", webrtc.MimeTypeH264, []byte{0x41}, false},
+		{"H264 STAP-A with SPS", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42}, true},
+		{"H264 STAP-A with IDR after slice", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x01, 0x41, 0x00, 0x01, 0x65}, true},
+		{"H264 STAP-A without keyframe", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x41, 0x00}, false},
+		{"H264 FU-A start of IDR", webrtc.MimeTypeH264, []byte{0x7C, 0x85}, true},
+		{"H264 FU-A continuation of IDR", webrtc.MimeTypeH264, []byte{0x7C, 0x05}, false},
+		{"H264 FU-A start of slice", webrtc.MimeTypeH264, []byte{0x7C, 0x81}, false},
+
+		{"AV1 new coded video sequence", webrtc.MimeTypeAV1, []byte{0x08}, true},
+		{"AV1 other packet", webrtc.MimeTypeAV1, []byte{0x10}, false},
+
+		{"unknown codec", webrtc.MimeTypeOpus, []byte{0x10, 0x00}, false},
+		{"empty payload", webrtc.MimeTypeVP8, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsKeyFrame(tt.mimeType, tt.payload); got != tt.want {
+				t.Errorf("IsKeyFrame(%s, % x) = %t, want %t", tt.mimeType, tt.payload, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package track
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// allocateAudioSlots reassigns the audio slots of every room with Last-N forwarding. Each room
+// forwards the audio of at most N users: new speakers take the slot of the holder who has been
+// quiet the longest, and free slots go to other publishers so small rooms forward everyone.
+// Tracks without audio levels never rank as speakers, so they are forwarded outside the slots.
+func (m *Manager) allocateAudioSlots(rankings map[string]roomRanking, lastN func(roomID string) int) {
+	m.mu.RLock()
+	rooms := make(map[string][]*PublishedTrack, len(m.roomTracks))
+	for roomID, roomTracks := range m.roomTracks {
+		for _, publishedTrack := range roomTracks {
+			if publishedTrack.Kind() == webrtc.RTPCodecTypeAudio {
+				rooms[roomID] = append(rooms[roomID], publishedTrack)
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	// Forget the slots of rooms without audio
+	for roomID := range m.audioSlots {
+		if _, exists := rooms[roomID]; !exists {
+			delete(m.audioSlots, roomID)
+		}
+	}
+
+	now := time.Now()
+	for roomID, tracks := range rooms {
+		n := lastN(roomID)
+		if n <= 0 {
+			if _, exists := m.audioSlots[roomID]; exists {
+				delete(m.audioSlots, roomID)
+				m.debugLog("🔊 Last-N forwarding disabled in room '%s'", roomID)
+			}
+			for _, publishedTrack := range tracks {
+				publishedTrack.setSilenced(false)
+			}
+			continue
+		}
+
+		publishers := make(map[string]bool, len(tracks))
+		for _, publishedTrack := range tracks {
+			if publishedTrack.reportsLevels.Load() {
+				publishers[publishedTrack.StreamID()] = true
+			}
+		}
+
+		slots, exists := m.audioSlots[roomID]
+		if !exists {
+			slots = make(map[string]time.Time)
+			m.audioSlots[roomID] = slots
+		}
+		for userID := range slots {
+			if !publishers[userID] {
+				delete(slots, userID)
+			}
+		}
+
+		for _, speaker := range rankings[roomID].speakers {
+			if !publishers[speaker.UserID] {
+				continue
+			}
+			if _, holdsSlot := slots[speaker.UserID]; holdsSlot {
+				slots[speaker.UserID] = now
+				continue
+			}
+
+			if len(slots) >= n {
+				victim, found := quietestSlot(slots, now)
+				if !found {
+					// Every slot is held by a louder speaker
+					break
+				}
+				delete(slots, victim)
+				m.debugLog("🔊 %s takes the audio slot of %s in room '%s'", speaker.UserID, victim, roomID)
+			}
+			slots[speaker.UserID] = now
+		}
+
+		// Free slots go to publishers that have not spoken, in a stable order
+		if len(slots) < n {
+			waiting := make([]string, 0, len(publishers))
+			for userID := range publishers {
+				if _, holdsSlot := slots[userID]; !holdsSlot {
+					waiting = append(waiting, userID)
+				}
+			}
+			sort.Strings(waiting)
+			for _, userID := range waiting {
+				if len(slots) >= n {
+					break
+				}
+				slots[userID] = time.Time{}
+			}
+		}
+
+		for _, publishedTrack := range tracks {
+			_, holdsSlot := slots[publishedTrack.StreamID()]
+			publishedTrack.setSilenced(publishedTrack.reportsLevels.Load() && !holdsSlot)
+		}
+	}
+}
+
+// quietestSlot returns the slot holder that spoke least recently, ignoring holders that spoke now
+func quietestSlot(slots map[string]time.Time, now time.Time) (string, bool) {
+	victim := ""
+	found := false
+	for userID, lastSpoke := range slots {
+		if !lastSpoke.Before(now) {
+			continue
+		}
+		if !found || lastSpoke.Before(slots[victim]) || (lastSpoke.Equal(slots[victim]) && userID < victim) {
+			victim = userID
+			found = true
+		}
+	}
+	return victim, found
+}
@@ -0,0 +1,115 @@
+package track
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/pkg/types"
+)
+
+// newLastNManager creates a track manager with one audio track per user in a room.
+// Users listed in withoutLevels publish audio without the audio level extension.
+func newLastNManager(userIDs []string, withoutLevels ...string) *Manager {
+	m := NewManager(false, 0)
+	m.roomTracks["room"] = make(map[string]*PublishedTrack)
+	for _, userID := range userIDs {
+		publishedTrack := newTestTrack(webrtc.RTPCodecTypeAudio, opus, "")
+		publishedTrack.id = userID + "-audio"
+		publishedTrack.streamID = userID
+		publishedTrack.SetReportsAudioLevels(true)
+		m.roomTracks["room"][publishedTrack.id] = publishedTrack
+	}
+	for _, userID := range withoutLevels {
+		m.roomTracks["room"][userID+"-audio"].SetReportsAudioLevels(false)
+	}
+	return m
+}
+
+// forwardedUsers returns the users whose audio is forwarded in a room, sorted
+func forwardedUsers(m *Manager, roomID string) []string {
+	users := []string{}
+	for _, publishedTrack := range m.roomTracks[roomID] {
+		if !publishedTrack.silenced {
+			users = append(users, publishedTrack.streamID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// speakingRanking ranks the given users as the speakers of a room, loudest first
+func speakingRanking(userIDs ...string) map[string]roomRanking {
+	speakers := []types.ActiveSpeaker{}
+	for i, userID := range userIDs {
+		speakers = append(speakers, types.ActiveSpeaker{UserID: userID, Level: 1 - float64(i)/10})
+	}
+	return map[string]roomRanking{"room": {speakers: speakers}}
+}
+
+func TestAllocateAudioSlots(t *testing.T) {
+	tests := []struct {
+		name          string
+		users         []string
+		withoutLevels []string
+		lastN         int
+		speakers      []string
+		want          []string
+	}{
+		{"Last-N disabled", []string{"a", "b", "c"}, nil, 0, nil, []string{"a", "b", "c"}},
+		{"fewer users than slots", []string{"a", "b"}, nil, 3, nil, []string{"a", "b"}},
+		{"speakers take slots first", []string{"a", "b", "c", "d"}, nil, 2, []string{"d", "c"}, []string{"c", "d"}},
+		{"free slots go to quiet users", []string{"a", "b", "c", "d"}, nil, 2, []string{"c"}, []string{"a", "c"}},
+		{"speakers beyond N stay silenced", []string{"a", "b", "c"}, nil, 1, []string{"b", "c"}, []string{"b"}},
+		{"speakers not publishing are ignored", []string{"a", "b"}, nil, 1, []string{"z"}, []string{"a"}},
+		{"audio without levels is always forwarded", []string{"a", "b", "x"}, []string{"x"}, 1, []string{"b"}, []string{"b", "x"}},
+		{"audio without levels takes no slot", []string{"a", "x", "y"}, []string{"x", "y"}, 1, nil, []string{"a", "x", "y"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLastNManager(tt.users, tt.withoutLevels...)
+			m.allocateAudioSlots(speakingRanking(tt.speakers...), func(string) int { return tt.lastN })
+
+			if got := forwardedUsers(m, "room"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forwarded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateAudioSlotsOverTime(t *testing.T) {
+	m := newLastNManager([]string{"a", "b", "c", "d"})
+	lastN := func(string) int { return 2 }
+
+	rounds := []struct {
+		name     string
+		speakers []string
+		want     []string
+	}{
+		{"nobody speaks", nil, []string{"a", "b"}},
+		{"new speaker takes a quiet slot", []string{"c"}, []string{"b", "c"}},
+		{"next speaker takes the other quiet slot", []string{"d", "c"}, []string{"c", "d"}},
+		{"holders keep slots while speaking", []string{"d"}, []string{"c", "d"}},
+		{"speakers take the slots of the quietest holders", []string{"a", "b", "c"}, []string{"a", "b"}},
+	}
+
+	for _, round := range rounds {
+		m.allocateAudioSlots(speakingRanking(round.speakers...), lastN)
+		if got := forwardedUsers(m, "room"); !reflect.DeepEqual(got, round.want) {
+			t.Errorf("%s: forwarded %v, want %v", round.name, got, round.want)
+		}
+		// Keep the times speakers took their slots apart
+		time.Sleep(time.Millisecond)
+	}
+
+	// Slots of rooms without audio are forgotten
+	delete(m.roomTracks, "room")
+	m.allocateAudioSlots(nil, lastN)
+	if _, exists := m.audioSlots["room"]; exists {
+		t.Error("slots kept for a room without audio")
+	}
+}
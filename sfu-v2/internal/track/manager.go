@@ -1,25 +1,51 @@
 package track
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/pkg/types"
 )
 
+// ErrTrackLimit is returned when adding a track would exceed a room or SFU-wide track limit
+var ErrTrackLimit = errors.New("track limit reached")
+
+// ErrTrackConflict is returned when a track reuses the ID of a track that is already published
+var ErrTrackConflict = errors.New("track ID already in use")
+
+// ErrTrackNotFound is returned when a track or a subscription to it does not exist
+var ErrTrackNotFound = errors.New("track not found")
+
+// layerAllocationInterval is how often simulcast layers are re-selected for every subscriber
+const layerAllocationInterval = 500 * time.Millisecond
+
 // Manager handles the lifecycle of media tracks per room
 type Manager struct {
 	mu sync.RWMutex
 	// Map of roomID -> trackID -> track
-	roomTracks map[string]map[string]*webrtc.TrackLocalStaticRTP
+	roomTracks map[string]map[string]*PublishedTrack
+	// maxTotalTracks caps the number of tracks across all rooms, zero means unlimited
+	maxTotalTracks int
+	// speakers ranks the active speakers of each room once detection is started
+	speakers *speakerDetector
+	// audioSlots holds the users whose audio each Last-N room forwards, with the time they
+	// last spoke. It is only used by the speaker detection goroutine.
+	audioSlots map[string]map[string]time.Time
 	debug      bool
 }
 
 // NewManager creates a new track manager
-func NewManager(debug bool) *Manager {
+func NewManager(debug bool, maxTotalTracks int) *Manager {
 	return &Manager{
-		roomTracks: make(map[string]map[string]*webrtc.TrackLocalStaticRTP),
-		debug:      debug,
+		roomTracks:     make(map[string]map[string]*PublishedTrack),
+		maxTotalTracks: maxTotalTracks,
+		audioSlots:     make(map[string]map[string]time.Time),
+		debug:          debug,
 	}
 }
 
@@ -30,50 +56,105 @@ func (m *Manager) debugLog(format string, args ...interface{}) {
 	}
 }
 
-// AddTrackToRoom adds a new media track to a specific room
-func (m *Manager) AddTrackToRoom(roomID string, t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
+// AddTrackToRoom adds a remote track published by a user to a specific room. Simulcast layers
+// share their track ID, so the first layer adds the track and later layers of the same
+// publisher join it; added reports whether the track is new. Any other reuse of a published
+// track ID is rejected with ErrTrackConflict. Subscribers see the user ID as the track's stream ID so
+// they can map streams to users. maxRoomTracks caps the tracks in this room, zero means
+// unlimited. requestKeyFrame asks the publisher for a keyframe on a layer.
+func (m *Manager) AddTrackToRoom(roomID, userID string, t *webrtc.TrackRemote, maxRoomTracks int, requestKeyFrame func(webrtc.SSRC)) (*PublishedTrack, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Create a new local track with the same codec as the incoming remote track
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		m.debugLog("❌ Error creating local track for room '%s': %v", roomID, err)
-		return nil
+	publishedTrack, exists := m.roomTracks[roomID][t.ID()]
+	if exists && (t.RID() == "" || !publishedTrack.Simulcast() || publishedTrack.StreamID() != userID) {
+		m.debugLog("❌ Rejecting track from '%s': ID %s is already published in room '%s'", userID, t.ID(), roomID)
+		return nil, false, fmt.Errorf("%w: %s", ErrTrackConflict, t.ID())
 	}
+	if !exists {
+		if err := m.checkTrackLimitsLocked(roomID, userID, maxRoomTracks); err != nil {
+			return nil, false, err
+		}
+
+		publishedTrack = newPublishedTrack(t, userID, requestKeyFrame)
 
-	// Initialize room tracks map if it doesn't exist
-	if m.roomTracks[roomID] == nil {
-		m.roomTracks[roomID] = make(map[string]*webrtc.TrackLocalStaticRTP)
-		m.debugLog("🏠 Initialized track storage for room '%s'", roomID)
+		// Initialize room tracks map if it doesn't exist
+		if m.roomTracks[roomID] == nil {
+			m.roomTracks[roomID] = make(map[string]*PublishedTrack)
+			m.debugLog("🏠 Initialized track storage for room '%s'", roomID)
+		}
+		m.roomTracks[roomID][t.ID()] = publishedTrack
 	}
 
-	// Store the local track in the room
-	m.roomTracks[roomID][t.ID()] = trackLocal
+	if !publishedTrack.addLayer(t.RID(), t.SSRC()) {
+		m.debugLog("❌ Rejecting simulcast layer '%s' from '%s': track %s already has it", t.RID(), userID, t.ID())
+		return nil, false, fmt.Errorf("%w: layer %s of %s", ErrTrackConflict, t.RID(), t.ID())
+	}
 
 	roomTrackCount := len(m.roomTracks[roomID])
-	m.debugLog("🎵 Added track to room '%s': ID=%s, StreamID=%s, Kind=%s (Room tracks: %d)",
-		roomID, t.ID(), t.StreamID(), t.Kind().String(), roomTrackCount)
+	if t.RID() != "" {
+		m.debugLog("🎞️  Added simulcast layer '%s' to room '%s': ID=%s, User=%s, SSRC=%d (Room tracks: %d)",
+			t.RID(), roomID, t.ID(), userID, t.SSRC(), roomTrackCount)
+	} else {
+		m.debugLog("🎵 Added track to room '%s': ID=%s, User=%s, Kind=%s (Room tracks: %d)",
+			roomID, t.ID(), userID, t.Kind().String(), roomTrackCount)
+	}
 
-	return trackLocal
+	return publishedTrack, !exists, nil
 }
 
-// RemoveTrackFromRoom removes a media track from a specific room
-func (m *Manager) RemoveTrackFromRoom(roomID string, t *webrtc.TrackLocalStaticRTP) {
+// checkTrackLimitsLocked returns ErrTrackLimit if a room or the SFU cannot take another track.
+// The caller must hold m.mu.
+func (m *Manager) checkTrackLimitsLocked(roomID, userID string, maxRoomTracks int) error {
+	if maxRoomTracks > 0 && len(m.roomTracks[roomID]) >= maxRoomTracks {
+		m.debugLog("❌ Rejecting track from '%s': room '%s' has %d/%d tracks", userID, roomID, len(m.roomTracks[roomID]), maxRoomTracks)
+		return fmt.Errorf("%w: room %s allows %d tracks", ErrTrackLimit, roomID, maxRoomTracks)
+	}
+
+	if m.maxTotalTracks > 0 && m.countTotalTracksLocked() >= m.maxTotalTracks {
+		m.debugLog("❌ Rejecting track from '%s': SFU has %d/%d tracks", userID, m.countTotalTracksLocked(), m.maxTotalTracks)
+		return fmt.Errorf("%w: SFU allows %d tracks", ErrTrackLimit, m.maxTotalTracks)
+	}
+	return nil
+}
+
+// countTotalTracksLocked returns the number of tracks across all rooms.
+// The caller must hold m.mu.
+func (m *Manager) countTotalTracksLocked() int {
+	total := 0
+	for _, tracks := range m.roomTracks {
+		total += len(tracks)
+	}
+	return total
+}
+
+// RemoveTrackFromRoom removes a layer of a published track from a specific room. Removing
+// the last layer removes the track from the room, which is reported by the return value.
+func (m *Manager) RemoveTrackFromRoom(roomID string, t *PublishedTrack, rid string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if t == nil {
+		m.debugLog("❌ Track not found in room '%s'", roomID)
+		return false
+	}
+
+	if t.removeLayer(rid) > 0 {
+		m.debugLog("🗑️  Removed simulcast layer '%s' of track %s from room '%s'", rid, t.ID(), roomID)
+		return false
+	}
+
 	// Check if the room exists
 	roomTracks, roomExists := m.roomTracks[roomID]
 	if !roomExists {
 		m.debugLog("❌ Cannot remove track: room '%s' does not exist", roomID)
-		return
+		return false
 	}
 
-	// Check if the track exists
-	if t == nil || roomTracks[t.ID()] == nil {
-		m.debugLog("❌ Track or track ID not found in room '%s'", roomID)
-		return
+	// Check the track was not replaced by a newer one with the same ID
+	if roomTracks[t.ID()] != t {
+		m.debugLog("❌ Track %s not found in room '%s'", t.ID(), roomID)
+		return false
 	}
 
 	// Remove the track from the room
@@ -86,21 +167,22 @@ func (m *Manager) RemoveTrackFromRoom(roomID string, t *webrtc.TrackLocalStaticR
 		delete(m.roomTracks, roomID)
 		m.debugLog("🧹 Cleaned up empty track storage for room '%s'", roomID)
 	}
+	return true
 }
 
 // GetTracksInRoom returns a copy of all tracks in a specific room
-func (m *Manager) GetTracksInRoom(roomID string) map[string]*webrtc.TrackLocalStaticRTP {
+func (m *Manager) GetTracksInRoom(roomID string) map[string]*PublishedTrack {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	roomTracks, exists := m.roomTracks[roomID]
 	if !exists {
 		m.debugLog("📭 No tracks found for room '%s'", roomID)
-		return make(map[string]*webrtc.TrackLocalStaticRTP)
+		return make(map[string]*PublishedTrack)
 	}
 
 	// Create a copy to avoid race conditions
-	tracks := make(map[string]*webrtc.TrackLocalStaticRTP)
+	tracks := make(map[string]*PublishedTrack)
 	for id, track := range roomTracks {
 		tracks[id] = track
 	}
@@ -110,7 +192,7 @@ func (m *Manager) GetTracksInRoom(roomID string) map[string]*webrtc.TrackLocalSt
 }
 
 // GetTrackInRoom returns a specific track by ID from a specific room
-func (m *Manager) GetTrackInRoom(roomID, trackID string) (*webrtc.TrackLocalStaticRTP, bool) {
+func (m *Manager) GetTrackInRoom(roomID, trackID string) (*PublishedTrack, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -123,6 +205,131 @@ func (m *Manager) GetTrackInRoom(roomID, trackID string) (*webrtc.TrackLocalStat
 	return track, exists
 }
 
+// RefreshUserMute applies the server-side mute of a user to every audio track they publish in a room.
+// isMuted is read under the manager lock, so a track published while the mute changes cannot miss it.
+func (m *Manager) RefreshUserMute(roomID, userID string, isMuted func() bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	muted := isMuted()
+	for _, publishedTrack := range m.roomTracks[roomID] {
+		if publishedTrack.StreamID() == userID && publishedTrack.Kind() == webrtc.RTPCodecTypeAudio {
+			publishedTrack.muted.Store(muted)
+		}
+	}
+}
+
+// SetVideoQuality caps the simulcast quality a user receives for a track in a room
+func (m *Manager) SetVideoQuality(roomID, userID, trackID, quality string) error {
+	publishedTrack, exists := m.GetTrackInRoom(roomID, trackID)
+	if !exists {
+		return fmt.Errorf("%w: no track %s in room %s", ErrTrackNotFound, trackID, roomID)
+	}
+
+	downTrack, subscribed := publishedTrack.subscriber(userID)
+	if !subscribed {
+		return fmt.Errorf("%w: %s does not receive track %s", ErrTrackNotFound, userID, trackID)
+	}
+
+	downTrack.setMaxQuality(quality)
+	m.debugLog("🎚️  %s capped track %s in room '%s' at %s quality", userID, trackID, roomID, quality)
+	return nil
+}
+
+// StartLayerAllocator starts a goroutine that periodically selects the simulcast layer
+// every subscriber receives, from the layer bitrates and the subscriber's bandwidth
+func (m *Manager) StartLayerAllocator() {
+	go func() {
+		ticker := time.NewTicker(layerAllocationInterval)
+		defer ticker.Stop()
+
+		m.debugLog("🎞️  Simulcast layer allocator started (interval: %s)", layerAllocationInterval)
+
+		lastRun := time.Now()
+		for now := range ticker.C {
+			m.allocateLayers(now.Sub(lastRun))
+			lastRun = now
+		}
+	}()
+}
+
+// allocateLayers samples layer bitrates and re-selects the layers of all simulcast subscribers
+func (m *Manager) allocateLayers(elapsed time.Duration) {
+	m.mu.RLock()
+	rooms := make([][]*PublishedTrack, 0, len(m.roomTracks))
+	for _, roomTracks := range m.roomTracks {
+		tracks := make([]*PublishedTrack, 0, len(roomTracks))
+		for _, publishedTrack := range roomTracks {
+			if publishedTrack.Simulcast() {
+				tracks = append(tracks, publishedTrack)
+			}
+		}
+		rooms = append(rooms, tracks)
+	}
+	m.mu.RUnlock()
+
+	for _, tracks := range rooms {
+		// A subscriber's bandwidth is shared by the simulcast tracks it receives
+		subscriptions := make(map[string]int)
+		for _, publishedTrack := range tracks {
+			publishedTrack.updateBitrates(elapsed)
+			for _, userID := range publishedTrack.subscriberIDs() {
+				subscriptions[userID]++
+			}
+		}
+
+		for _, publishedTrack := range tracks {
+			publishedTrack.allocate(subscriptions)
+		}
+	}
+}
+
+// StartSpeakerDetection starts a goroutine that periodically ranks the loudest speakers of
+// every room from the recorded audio levels and reports rooms whose ranking changed. Rooms
+// with Last-N forwarding then get their audio slots reassigned from the same ranking.
+// It must be called before audio levels are recorded.
+func (m *Manager) StartSpeakerDetection(config SpeakerConfig, onChange ActiveSpeakersFunc) {
+	m.speakers = newSpeakerDetector(config)
+
+	go func() {
+		ticker := time.NewTicker(speakerDetectionInterval)
+		defer ticker.Stop()
+
+		m.debugLog("🗣️  Active speaker detection started (window: %s, speakers: %d)", config.Window, config.MaxSpeakers)
+
+		for range ticker.C {
+			rankings := m.speakers.rank()
+			for roomID, ranking := range rankings {
+				if ranking.changed {
+					m.debugLog("🗣️  Active speakers in room '%s': %d", roomID, len(ranking.reported))
+					onChange(roomID, ranking.reported)
+				}
+			}
+
+			if config.LastN != nil {
+				m.allocateAudioSlots(rankings, config.LastN)
+			}
+		}
+	}()
+}
+
+// RecordAudioLevel records the audio level of a packet a user sent to a room, in -dBov from
+// 0 (loudest) to 127 (silence). Levels are ignored while speaker detection is not started.
+func (m *Manager) RecordAudioLevel(roomID, userID string, level uint8) {
+	if m.speakers == nil {
+		return
+	}
+	m.speakers.record(roomID, userID, level)
+}
+
+// GetActiveSpeakers returns the last ranked speakers of a room, loudest first
+func (m *Manager) GetActiveSpeakers(roomID string) []types.ActiveSpeaker {
+	if m.speakers == nil {
+		return []types.ActiveSpeaker{}
+	}
+	return m.speakers.activeSpeakers(roomID)
+}
+
 // GetRoomStats returns statistics about tracks per room
 func (m *Manager) GetRoomStats() map[string]int {
 	m.mu.RLock()
@@ -135,6 +342,35 @@ func (m *Manager) GetRoomStats() map[string]int {
 	return stats
 }
 
+// GetCodecStats returns the negotiated codecs of all forwarded tracks, with their track counts
+func (m *Manager) GetCodecStats() []types.CodecStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := []types.CodecStats{}
+	// index maps a codec, keyed with a zero track count, to its position in stats
+	index := make(map[types.CodecStats]int)
+	for _, tracks := range m.roomTracks {
+		for _, t := range tracks {
+			codec := t.Codec()
+			key := types.CodecStats{
+				MimeType:    codec.MimeType,
+				ClockRate:   codec.ClockRate,
+				Channels:    codec.Channels,
+				SDPFmtpLine: codec.SDPFmtpLine,
+			}
+			i, exists := index[key]
+			if !exists {
+				i = len(stats)
+				index[key] = i
+				stats = append(stats, key)
+			}
+			stats[i].Tracks++
+		}
+	}
+	return stats
+}
+
 // CleanupEmptyRooms removes track storage for rooms with no tracks
 func (m *Manager) CleanupEmptyRooms() {
 	m.mu.Lock()
@@ -0,0 +1,46 @@
+package track
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRefreshUserMute(t *testing.T) {
+	m := NewManager(false, 0)
+	m.roomTracks["room"] = make(map[string]*PublishedTrack)
+	for _, published := range []struct {
+		id, userID string
+		kind       webrtc.RTPCodecType
+		codec      webrtc.RTPCodecCapability
+	}{
+		{"alice-audio", "alice", webrtc.RTPCodecTypeAudio, opus},
+		{"alice-video", "alice", webrtc.RTPCodecTypeVideo, vp8},
+		{"bob-audio", "bob", webrtc.RTPCodecTypeAudio, opus},
+	} {
+		publishedTrack := newTestTrack(published.kind, published.codec, "")
+		publishedTrack.id = published.id
+		publishedTrack.streamID = published.userID
+		m.roomTracks["room"][published.id] = publishedTrack
+	}
+
+	mutedTracks := func() map[string]bool {
+		muted := map[string]bool{}
+		for id, publishedTrack := range m.roomTracks["room"] {
+			if publishedTrack.Muted() {
+				muted[id] = true
+			}
+		}
+		return muted
+	}
+
+	m.RefreshUserMute("room", "alice", func() bool { return true })
+	if muted := mutedTracks(); len(muted) != 1 || !muted["alice-audio"] {
+		t.Errorf("got muted tracks %v, want only alice-audio", muted)
+	}
+
+	m.RefreshUserMute("room", "alice", func() bool { return false })
+	if muted := mutedTracks(); len(muted) != 0 {
+		t.Errorf("got muted tracks %v after unmuting, want none", muted)
+	}
+}
@@ -0,0 +1,312 @@
+package track
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// upgradeHeadroom is the share of extra bandwidth, in percent, a subscriber needs
+// before switching up to a higher layer. It keeps layers from flapping.
+const upgradeHeadroom = 10
+
+// PublishedTrack is a media track published by a user and fanned out to subscribers.
+// A simulcast track has one layer per RID, each arriving as a separate remote track;
+// other tracks have a single layer with an empty RID. Every subscriber receives the
+// track through its own DownTrack, which forwards one layer at a time.
+type PublishedTrack struct {
+	id       string
+	streamID string
+	kind     webrtc.RTPCodecType
+	codec    webrtc.RTPCodecCapability
+	// simulcast is set for tracks whose layers carry RIDs
+	simulcast bool
+	// requestKeyFrame asks the publisher for a keyframe on the layer with the given SSRC
+	requestKeyFrame func(ssrc webrtc.SSRC)
+
+	mu          sync.RWMutex
+	layers      map[string]*trackLayer
+	subscribers map[string]*DownTrack
+	// silenced is set while Last-N forwarding leaves this audio track out, which pauses every subscriber
+	silenced bool
+	// reportsLevels is set for audio tracks carrying the audio level extension. Last-N forwarding
+	// cannot rank the others, so they are always forwarded.
+	reportsLevels atomic.Bool
+	// muted is set while the server mutes the publisher, whose packets are then dropped
+	muted atomic.Bool
+}
+
+// trackLayer is one encoding of a published track
+type trackLayer struct {
+	rid  string
+	ssrc webrtc.SSRC
+	// bytes counts the payload received since the last bitrate sample
+	bytes atomic.Uint64
+	// bitrate is the smoothed layer bitrate in bits per second, guarded by the track's mu
+	bitrate int
+}
+
+// layerInfo is a snapshot of a layer used for layer selection
+type layerInfo struct {
+	rid     string
+	ssrc    webrtc.SSRC
+	bitrate int
+}
+
+// newPublishedTrack creates a published track without layers
+func newPublishedTrack(t *webrtc.TrackRemote, streamID string, requestKeyFrame func(webrtc.SSRC)) *PublishedTrack {
+	return &PublishedTrack{
+		id:              t.ID(),
+		streamID:        streamID,
+		kind:            t.Kind(),
+		codec:           t.Codec().RTPCodecCapability,
+		simulcast:       t.RID() != "",
+		requestKeyFrame: requestKeyFrame,
+		layers:          make(map[string]*trackLayer),
+		subscribers:     make(map[string]*DownTrack),
+	}
+}
+
+// ID returns the track ID shared by all layers
+func (t *PublishedTrack) ID() string {
+	return t.id
+}
+
+// StreamID returns the stream ID, the publishing user's ID
+func (t *PublishedTrack) StreamID() string {
+	return t.streamID
+}
+
+// Kind returns whether the track is audio or video
+func (t *PublishedTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+// Codec returns the codec of the track
+func (t *PublishedTrack) Codec() webrtc.RTPCodecCapability {
+	return t.codec
+}
+
+// Simulcast reports whether the track is published in several simulcast layers
+func (t *PublishedTrack) Simulcast() bool {
+	return t.simulcast
+}
+
+// SetReportsAudioLevels records whether the track's packets carry the audio level extension
+func (t *PublishedTrack) SetReportsAudioLevels(reports bool) {
+	t.reportsLevels.Store(reports)
+}
+
+// Muted reports whether the server muted the publisher of the track.
+// It is read for every packet, so it is a lock-free flag.
+func (t *PublishedTrack) Muted() bool {
+	return t.muted.Load()
+}
+
+// addLayer registers a layer received from the publisher, unless the track already has it
+func (t *PublishedTrack) addLayer(rid string, ssrc webrtc.SSRC) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.layers[rid]; exists {
+		return false
+	}
+	t.layers[rid] = &trackLayer{rid: rid, ssrc: ssrc}
+	return true
+}
+
+// removeLayer drops a layer and returns the number of layers left
+func (t *PublishedTrack) removeLayer(rid string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.layers, rid)
+	return len(t.layers)
+}
+
+// WriteRTP forwards a packet of one layer to every subscriber currently receiving that layer.
+// Subscribers waiting to switch to the layer switch on its next keyframe.
+func (t *PublishedTrack) WriteRTP(rid string, packet *rtp.Packet) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	layer, exists := t.layers[rid]
+	if !exists {
+		return
+	}
+	layer.bytes.Add(uint64(len(packet.Payload)))
+
+	// Audio can be joined at any packet, video only at a keyframe
+	keyFrame := t.kind == webrtc.RTPCodecTypeAudio || IsKeyFrame(t.codec.MimeType, packet.Payload)
+	for _, downTrack := range t.subscribers {
+		downTrack.writeRTP(rid, packet, keyFrame)
+	}
+}
+
+// Resync makes every subscriber continue its sequence numbers and timestamps across a gap
+// the publisher's packets were deliberately not forwarded for, such as a server-side mute
+func (t *PublishedTrack) Resync() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, downTrack := range t.subscribers {
+		downTrack.resyncNext()
+	}
+}
+
+// Subscribe creates the DownTrack a user receives this track through.
+// bandwidth returns the user's estimated downstream bandwidth in bits per second, zero if unknown.
+// A previous subscription of the same user is replaced.
+func (t *PublishedTrack) Subscribe(userID string, bandwidth func() int) *DownTrack {
+	downTrack := newDownTrack(t, userID, bandwidth)
+
+	t.mu.Lock()
+	downTrack.paused = t.silenced
+	t.subscribers[userID] = downTrack
+	layers := t.sortedLayersLocked()
+	t.mu.Unlock()
+
+	// Start on the lowest layer until the allocator has measured the subscriber's bandwidth
+	if len(layers) > 0 {
+		downTrack.switchTo(layers[0].rid)
+		if t.kind == webrtc.RTPCodecTypeVideo {
+			t.requestKeyFrame(layers[0].ssrc)
+		}
+	}
+	return downTrack
+}
+
+// Unsubscribe removes a subscriber unless it was already replaced by a newer one
+func (t *PublishedTrack) Unsubscribe(downTrack *DownTrack) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.subscribers[downTrack.userID] == downTrack {
+		delete(t.subscribers, downTrack.userID)
+	}
+}
+
+// setSilenced pauses or resumes forwarding the track to all its subscribers
+func (t *PublishedTrack) setSilenced(silenced bool) {
+	t.mu.Lock()
+	if t.silenced == silenced {
+		t.mu.Unlock()
+		return
+	}
+	t.silenced = silenced
+	subscribers := make([]*DownTrack, 0, len(t.subscribers))
+	for _, downTrack := range t.subscribers {
+		subscribers = append(subscribers, downTrack)
+	}
+	t.mu.Unlock()
+
+	// Resuming video requests a keyframe, which takes t.mu
+	for _, downTrack := range subscribers {
+		if silenced {
+			downTrack.Pause()
+		} else {
+			downTrack.Resume()
+		}
+	}
+}
+
+// subscriber returns the DownTrack of a user
+func (t *PublishedTrack) subscriber(userID string) (*DownTrack, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	downTrack, exists := t.subscribers[userID]
+	return downTrack, exists
+}
+
+// subscriberIDs returns the IDs of the subscribed users
+func (t *PublishedTrack) subscriberIDs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	userIDs := make([]string, 0, len(t.subscribers))
+	for userID := range t.subscribers {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// requestLayerKeyFrame asks the publisher for a keyframe on one layer
+func (t *PublishedTrack) requestLayerKeyFrame(rid string) {
+	t.mu.RLock()
+	layer, exists := t.layers[rid]
+	t.mu.RUnlock()
+
+	if exists {
+		t.requestKeyFrame(layer.ssrc)
+	}
+}
+
+// updateBitrates samples the bitrate of every layer over the elapsed interval
+func (t *PublishedTrack) updateBitrates(elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, layer := range t.layers {
+		sample := int(float64(layer.bytes.Swap(0)*8) / elapsed.Seconds())
+		// Smooth the samples, bursty keyframes would otherwise make layers jump
+		layer.bitrate = (layer.bitrate + sample) / 2
+	}
+}
+
+// sortedLayersLocked returns the layers ordered from lowest to highest bitrate. Layers the
+// publisher stopped sending are left out, unless no layer has been measured yet.
+// The caller must hold t.mu.
+func (t *PublishedTrack) sortedLayersLocked() []layerInfo {
+	active := make([]layerInfo, 0, len(t.layers))
+	all := make([]layerInfo, 0, len(t.layers))
+	for _, layer := range t.layers {
+		info := layerInfo{rid: layer.rid, ssrc: layer.ssrc, bitrate: layer.bitrate}
+		all = append(all, info)
+		if layer.bitrate > 0 {
+			active = append(active, info)
+		}
+	}
+
+	layers := active
+	if len(layers) == 0 {
+		layers = all
+	}
+	sort.Slice(layers, func(i, j int) bool {
+		if layers[i].bitrate != layers[j].bitrate {
+			return layers[i].bitrate < layers[j].bitrate
+		}
+		return layers[i].rid < layers[j].rid
+	})
+	return layers
+}
+
+// allocate picks the layer of every subscriber of a simulcast track. subscriptions counts the
+// simulcast tracks each user receives in the room, their bandwidth is shared evenly between them.
+func (t *PublishedTrack) allocate(subscriptions map[string]int) {
+	t.mu.RLock()
+	layers := t.sortedLayersLocked()
+	subscribers := make([]*DownTrack, 0, len(t.subscribers))
+	for _, downTrack := range t.subscribers {
+		subscribers = append(subscribers, downTrack)
+	}
+	t.mu.RUnlock()
+
+	if len(layers) == 0 {
+		return
+	}
+
+	for _, downTrack := range subscribers {
+		budget := downTrack.bandwidth()
+		if count := subscriptions[downTrack.userID]; budget > 0 && count > 1 {
+			budget /= count
+		}
+		if ssrc, switching := downTrack.selectLayer(layers, budget); switching {
+			t.requestKeyFrame(ssrc)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package track
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"sfu-v2/pkg/types"
+)
+
+// speakerDetectionInterval is how often the speakers of every room are ranked
+const speakerDetectionInterval = 300 * time.Millisecond
+
+// SpeakerConfig tunes active speaker detection
+type SpeakerConfig struct {
+	// Window is the sliding window audio levels are averaged over
+	Window time.Duration
+	// MaxSpeakers is the number of loudest speakers reported per room
+	MaxSpeakers int
+	// Threshold is the audio level in -dBov from which a packet counts as silence
+	Threshold uint8
+	// LastN returns how many speakers' audio a room forwards, zero forwards all. Nil disables Last-N.
+	LastN func(roomID string) int
+}
+
+// ActiveSpeakersFunc is called with the ranked speakers of a room whenever they change
+type ActiveSpeakersFunc func(roomID string, speakers []types.ActiveSpeaker)
+
+// speakerDetector ranks the users of each room by the audio levels their packets carry
+type speakerDetector struct {
+	mu     sync.Mutex
+	config SpeakerConfig
+	// Map of roomID -> userID -> audio levels
+	rooms map[string]map[string]*speakerLevels
+	// ranked holds the speakers last reported per room
+	ranked map[string][]types.ActiveSpeaker
+}
+
+// speakerLevels holds a user's audio levels over the sliding window
+type speakerLevels struct {
+	// buckets holds one bucket per detection interval of the window, oldest first
+	buckets []levelBucket
+	current levelBucket
+}
+
+// roomRanking is the outcome of ranking the speakers of a room
+type roomRanking struct {
+	// speakers are all users speaking in the room, loudest first
+	speakers []types.ActiveSpeaker
+	// reported are the top speakers, changed is set when they changed since the last ranking
+	reported []types.ActiveSpeaker
+	changed  bool
+}
+
+// levelBucket sums the loudness of the packets received in one detection interval
+type levelBucket struct {
+	loudness int
+	packets  int
+}
+
+// newSpeakerDetector creates a detector with no rooms
+func newSpeakerDetector(config SpeakerConfig) *speakerDetector {
+	return &speakerDetector{
+		config: config,
+		rooms:  make(map[string]map[string]*speakerLevels),
+		ranked: make(map[string][]types.ActiveSpeaker),
+	}
+}
+
+// record adds the audio level of a packet, in -dBov from 0 (loudest) to 127 (silence)
+func (d *speakerDetector) record(roomID, userID string, level uint8) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	users, exists := d.rooms[roomID]
+	if !exists {
+		users = make(map[string]*speakerLevels)
+		d.rooms[roomID] = users
+	}
+	levels, exists := users[userID]
+	if !exists {
+		levels = &speakerLevels{}
+		users[userID] = levels
+	}
+
+	// Levels at or quieter than the threshold are silence
+	if level < d.config.Threshold {
+		levels.current.loudness += int(d.config.Threshold - level)
+	}
+	levels.current.packets++
+}
+
+// activeSpeakers returns the speakers last reported for a room, loudest first
+func (d *speakerDetector) activeSpeakers(roomID string) []types.ActiveSpeaker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]types.ActiveSpeaker(nil), d.ranked[roomID]...)
+}
+
+// rank closes the current interval of every user and ranks the speakers of each room
+// that received audio within the window
+func (d *speakerDetector) rank() map[string]roomRanking {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	windowBuckets := int(d.config.Window / speakerDetectionInterval)
+	if windowBuckets < 1 {
+		windowBuckets = 1
+	}
+
+	rankings := make(map[string]roomRanking)
+	for roomID, users := range d.rooms {
+		speakers := []types.ActiveSpeaker{}
+		for userID, levels := range users {
+			levels.buckets = append(levels.buckets, levels.current)
+			levels.current = levelBucket{}
+			if len(levels.buckets) > windowBuckets {
+				levels.buckets = levels.buckets[len(levels.buckets)-windowBuckets:]
+			}
+
+			total := levelBucket{}
+			for _, bucket := range levels.buckets {
+				total.loudness += bucket.loudness
+				total.packets += bucket.packets
+			}
+			if total.packets == 0 {
+				// The user stopped sending audio for a whole window
+				delete(users, userID)
+				continue
+			}
+
+			// Silent packets count as zero loudness, so short noises do not make a speaker.
+			// The level is the average loudness relative to the threshold, from 0 to 1.
+			if average := float64(total.loudness) / float64(total.packets); average >= 1 {
+				speakers = append(speakers, types.ActiveSpeaker{
+					UserID: userID,
+					Level:  average / float64(d.config.Threshold),
+				})
+			}
+		}
+
+		sort.Slice(speakers, func(i, j int) bool {
+			if speakers[i].Level != speakers[j].Level {
+				return speakers[i].Level > speakers[j].Level
+			}
+			return speakers[i].UserID < speakers[j].UserID
+		})
+		top := speakers
+		if len(top) > d.config.MaxSpeakers {
+			top = top[:d.config.MaxSpeakers]
+		}
+
+		rankings[roomID] = roomRanking{
+			speakers: speakers,
+			reported: top,
+			changed:  !sameSpeakers(top, d.ranked[roomID]),
+		}
+		if len(top) > 0 {
+			d.ranked[roomID] = top
+		} else {
+			delete(d.ranked, roomID)
+		}
+		if len(users) == 0 {
+			delete(d.rooms, roomID)
+		}
+	}
+	return rankings
+}
+
+// sameSpeakers reports whether two rankings name the same users in the same order
+func sameSpeakers(a, b []types.ActiveSpeaker) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UserID != b[i].UserID {
+			return false
+		}
+	}
+	return true
+}
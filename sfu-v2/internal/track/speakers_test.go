@@ -0,0 +1,143 @@
+package track
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sfu-v2/pkg/types"
+)
+
+// speakerIDs returns the user IDs of ranked speakers in order
+func speakerIDs(speakers []types.ActiveSpeaker) []string {
+	ids := []string{}
+	for _, speaker := range speakers {
+		ids = append(ids, speaker.UserID)
+	}
+	return ids
+}
+
+func TestSpeakerDetectorRank(t *testing.T) {
+	config := SpeakerConfig{Window: speakerDetectionInterval, MaxSpeakers: 2, Threshold: 60}
+
+	tests := []struct {
+		name string
+		// levels are the audio levels each user sends in the interval, in -dBov
+		levels       map[string][]uint8
+		wantSpeakers []string
+		wantReported []string
+		wantLevel    map[string]float64
+	}{
+		{
+			name:         "loudest first",
+			levels:       map[string][]uint8{"alice": {30, 30}, "bob": {10, 10}},
+			wantSpeakers: []string{"bob", "alice"},
+			wantReported: []string{"bob", "alice"},
+			wantLevel:    map[string]float64{"alice": 0.5, "bob": 50.0 / 60},
+		},
+		{
+			name:         "silence is not speaking",
+			levels:       map[string][]uint8{"alice": {60, 127}, "bob": {30}},
+			wantSpeakers: []string{"bob"},
+			wantReported: []string{"bob"},
+		},
+		{
+			name:         "short noise averaged out by silence",
+			levels:       map[string][]uint8{"alice": {59, 127, 127, 127}},
+			wantSpeakers: []string{},
+			wantReported: []string{},
+		},
+		{
+			name:         "top speakers reported",
+			levels:       map[string][]uint8{"alice": {40}, "bob": {20}, "carol": {30}},
+			wantSpeakers: []string{"bob", "carol", "alice"},
+			wantReported: []string{"bob", "carol"},
+		},
+		{
+			name:         "ties broken by user ID",
+			levels:       map[string][]uint8{"carol": {30}, "alice": {30}, "bob": {30}},
+			wantSpeakers: []string{"alice", "bob", "carol"},
+			wantReported: []string{"alice", "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := newSpeakerDetector(config)
+			for userID, levels := range tt.levels {
+				for _, level := range levels {
+					detector.record("room", userID, level)
+				}
+			}
+
+			ranking := detector.rank()["room"]
+			if got := speakerIDs(ranking.speakers); !reflect.DeepEqual(got, tt.wantSpeakers) {
+				t.Errorf("speakers = %v, want %v", got, tt.wantSpeakers)
+			}
+			if got := speakerIDs(ranking.reported); !reflect.DeepEqual(got, tt.wantReported) {
+				t.Errorf("reported = %v, want %v", got, tt.wantReported)
+			}
+			for _, speaker := range ranking.speakers {
+				if want, exists := tt.wantLevel[speaker.UserID]; exists && speaker.Level != want {
+					t.Errorf("level of %s = %f, want %f", speaker.UserID, speaker.Level, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSpeakerDetectorRankOverTime(t *testing.T) {
+	// The window spans two detection intervals
+	detector := newSpeakerDetector(SpeakerConfig{Window: 2 * speakerDetectionInterval, MaxSpeakers: 2, Threshold: 60})
+
+	rounds := []struct {
+		name         string
+		levels       map[string]uint8
+		wantReported []string
+		wantChanged  bool
+	}{
+		{"first speakers", map[string]uint8{"alice": 10, "bob": 30}, []string{"alice", "bob"}, true},
+		{"same order", map[string]uint8{"alice": 10, "bob": 30}, []string{"alice", "bob"}, false},
+		{"order flips", map[string]uint8{"alice": 50, "bob": 0}, []string{"bob", "alice"}, true},
+		{"alice keeps speaking in the window", map[string]uint8{"bob": 0}, []string{"bob", "alice"}, false},
+		{"alice leaves the window", map[string]uint8{"bob": 0}, []string{"bob"}, true},
+	}
+
+	for _, round := range rounds {
+		for userID, level := range round.levels {
+			detector.record("room", userID, level)
+		}
+
+		ranking := detector.rank()["room"]
+		if got := speakerIDs(ranking.reported); !reflect.DeepEqual(got, round.wantReported) {
+			t.Errorf("%s: reported = %v, want %v", round.name, got, round.wantReported)
+		}
+		if ranking.changed != round.wantChanged {
+			t.Errorf("%s: changed = %t, want %t", round.name, ranking.changed, round.wantChanged)
+		}
+		if got := speakerIDs(detector.activeSpeakers("room")); !reflect.DeepEqual(got, round.wantReported) {
+			t.Errorf("%s: active speakers = %v, want %v", round.name, got, round.wantReported)
+		}
+	}
+}
+
+func TestSpeakerDetectorForgetsQuietRooms(t *testing.T) {
+	detector := newSpeakerDetector(SpeakerConfig{Window: time.Nanosecond, MaxSpeakers: 1, Threshold: 60})
+	detector.record("room", "alice", 10)
+
+	if ranking := detector.rank()["room"]; len(ranking.reported) != 1 {
+		t.Fatalf("reported = %v, want alice", speakerIDs(ranking.reported))
+	}
+
+	// No audio for a whole window drops the user, then the room
+	ranking, ranked := detector.rank()["room"]
+	if !ranked || !ranking.changed || len(ranking.reported) != 0 {
+		t.Errorf("ranking = %+v, want an empty changed ranking", ranking)
+	}
+	if _, exists := detector.rank()["room"]; exists {
+		t.Error("room still ranked after it went quiet")
+	}
+	if speakers := detector.activeSpeakers("room"); len(speakers) != 0 {
+		t.Errorf("active speakers = %v, want none", speakerIDs(speakers))
+	}
+}
@@ -0,0 +1,202 @@
+package webrtc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// tcpMuxReadBufferSize is the number of packets buffered per ICE-TCP connection
+	tcpMuxReadBufferSize = 8
+	// initialBitrateEstimate is the downstream bandwidth assumed for a new peer, in bits per second
+	initialBitrateEstimate = 1_000_000
+)
+
+// Supported mDNS modes
+const (
+	MDNSModeDisabled = "disabled"
+	MDNSModeQuery    = "query"
+	MDNSModeGather   = "gather"
+)
+
+// APIConfig configures the ICE networking of the WebRTC API shared by all peer connections
+type APIConfig struct {
+	// UDPMuxPort serves ICE for every peer on one UDP port, zero uses a port per peer
+	UDPMuxPort int
+	// TCPMuxPort enables ICE-TCP on one TCP port, zero disables it
+	TCPMuxPort int
+	// NAT1To1IPs are public IPs advertised instead of the local host addresses
+	NAT1To1IPs []string
+	// EphemeralPortMin and EphemeralPortMax bound the per-peer UDP ports when the UDP mux is off
+	EphemeralPortMin uint16
+	EphemeralPortMax uint16
+	// Interfaces limits ICE gathering to these network interfaces, empty allows all
+	Interfaces []string
+	// MDNSMode is one of MDNSModeDisabled, MDNSModeQuery or MDNSModeGather
+	MDNSMode string
+	// Codecs selects the codecs offered to and accepted from peers
+	Codecs CodecConfig
+}
+
+// muxClosers closes the sockets behind the ICE muxes
+type muxClosers []io.Closer
+
+// Close closes every mux socket and returns the first error
+func (c muxClosers) Close() error {
+	var firstErr error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// API is the WebRTC API shared by all peer connections. It captures the bandwidth
+// estimator created for each peer connection.
+type API struct {
+	api *webrtc.API
+
+	// createMu serializes peer connection creation so the estimator handed to the
+	// congestion controller callback belongs to the peer connection being created
+	createMu  sync.Mutex
+	estimator cc.BandwidthEstimator
+}
+
+// NewPeerConnection creates a peer connection and returns its send-side bandwidth estimator
+func (a *API) NewPeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection, cc.BandwidthEstimator, error) {
+	a.createMu.Lock()
+	defer a.createMu.Unlock()
+
+	a.estimator = nil
+	peerConnection, err := a.api.NewPeerConnection(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return peerConnection, a.estimator, nil
+}
+
+// NewAPI builds the WebRTC API shared by all peer connections from a SettingEngine.
+// The returned closer releases the mux sockets and must be called on shutdown.
+func NewAPI(cfg APIConfig) (*API, io.Closer, error) {
+	settingEngine := webrtc.SettingEngine{}
+	closers := muxClosers{}
+
+	networkTypes := []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6}
+
+	if cfg.UDPMuxPort > 0 {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: cfg.UDPMuxPort})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen on ICE UDP port %d: %w", cfg.UDPMuxPort, err)
+		}
+		closers = append(closers, udpConn)
+		settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, udpConn))
+	} else if cfg.EphemeralPortMin > 0 || cfg.EphemeralPortMax > 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(cfg.EphemeralPortMin, cfg.EphemeralPortMax); err != nil {
+			return nil, nil, fmt.Errorf("invalid ephemeral UDP port range %d-%d: %w", cfg.EphemeralPortMin, cfg.EphemeralPortMax, err)
+		}
+	}
+
+	if cfg.TCPMuxPort > 0 {
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: cfg.TCPMuxPort})
+		if err != nil {
+			closers.Close()
+			return nil, nil, fmt.Errorf("failed to listen on ICE TCP port %d: %w", cfg.TCPMuxPort, err)
+		}
+		closers = append(closers, tcpListener)
+		settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, tcpListener, tcpMuxReadBufferSize))
+		networkTypes = append(networkTypes, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6)
+	}
+	settingEngine.SetNetworkTypes(networkTypes)
+
+	if len(cfg.NAT1To1IPs) > 0 {
+		settingEngine.SetNAT1To1IPs(cfg.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+
+	if len(cfg.Interfaces) > 0 {
+		allowed := make(map[string]bool, len(cfg.Interfaces))
+		for _, name := range cfg.Interfaces {
+			allowed[name] = true
+		}
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			return allowed[name]
+		})
+	}
+
+	switch cfg.MDNSMode {
+	case MDNSModeDisabled:
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	case MDNSModeQuery, "":
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryOnly)
+	case MDNSModeGather:
+		settingEngine.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryAndGather)
+	default:
+		closers.Close()
+		return nil, nil, fmt.Errorf("invalid mDNS mode %q", cfg.MDNSMode)
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := registerCodecs(mediaEngine, cfg.Codecs); err != nil {
+		closers.Close()
+		return nil, nil, err
+	}
+
+	// Simulcast layers are told apart by their MID and RID header extensions
+	for _, uri := range []string{sdp.SDESMidURI, sdp.SDESRTPStreamIDURI} {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, webrtc.RTPCodecTypeVideo); err != nil {
+			closers.Close()
+			return nil, nil, fmt.Errorf("failed to register header extension %s: %w", uri, err)
+		}
+	}
+
+	// Publishers report the level of their audio for active speaker detection
+	if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		closers.Close()
+		return nil, nil, fmt.Errorf("failed to register header extension %s: %w", sdp.AudioLevelURI, err)
+	}
+
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		closers.Close()
+		return nil, nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	// Estimate each subscriber's downstream bandwidth from its transport-wide congestion control
+	// feedback. Packets are not paced, the estimate only drives simulcast layer selection.
+	api := &API{}
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBitrateEstimate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		closers.Close()
+		return nil, nil, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		api.estimator = estimator
+	})
+	interceptorRegistry.Add(congestionController)
+
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, interceptorRegistry); err != nil {
+		closers.Close()
+		return nil, nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	api.api = webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	)
+	return api, closers, nil
+}
@@ -0,0 +1,146 @@
+package webrtc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// CodecConfig selects the codecs registered with the MediaEngine and tunes Opus
+type CodecConfig struct {
+	// AudioCodecs and VideoCodecs list the allowed codec names in order of preference
+	AudioCodecs []string
+	VideoCodecs []string
+	// Opus fmtp parameters, zero OpusMaxAverageBitrate leaves the bitrate to the sender
+	OpusStereo            bool
+	OpusFEC               bool
+	OpusDTX               bool
+	OpusMaxAverageBitrate int
+}
+
+// codecEntry is a codec registered under an allowlist name. Video codecs get an RTX
+// payload type for retransmissions.
+type codecEntry struct {
+	mimeType    string
+	clockRate   uint32
+	channels    uint16
+	fmtp        string
+	payloadType webrtc.PayloadType
+	rtxType     webrtc.PayloadType
+}
+
+// audioCodecs maps allowlist names to audio codecs, using pion's default payload types
+var audioCodecs = map[string][]codecEntry{
+	"opus": {{mimeType: webrtc.MimeTypeOpus, clockRate: 48000, channels: 2, payloadType: 111}},
+	"g722": {{mimeType: webrtc.MimeTypeG722, clockRate: 8000, payloadType: 9}},
+	"pcmu": {{mimeType: webrtc.MimeTypePCMU, clockRate: 8000, payloadType: 0}},
+	"pcma": {{mimeType: webrtc.MimeTypePCMA, clockRate: 8000, payloadType: 8}},
+}
+
+// videoCodecs maps allowlist names to video codecs, using pion's default payload types
+var videoCodecs = map[string][]codecEntry{
+	"vp8": {{mimeType: webrtc.MimeTypeVP8, clockRate: 90000, payloadType: 96, rtxType: 97}},
+	"vp9": {
+		{mimeType: webrtc.MimeTypeVP9, clockRate: 90000, fmtp: "profile-id=0", payloadType: 98, rtxType: 99},
+		{mimeType: webrtc.MimeTypeVP9, clockRate: 90000, fmtp: "profile-id=2", payloadType: 100, rtxType: 101},
+	},
+	"h264": {
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", payloadType: 102, rtxType: 103},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f", payloadType: 104, rtxType: 105},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", payloadType: 106, rtxType: 107},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f", payloadType: 108, rtxType: 109},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", payloadType: 127, rtxType: 125},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=4d001f", payloadType: 39, rtxType: 40},
+		{mimeType: webrtc.MimeTypeH264, clockRate: 90000, fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f", payloadType: 112, rtxType: 113},
+	},
+	"av1": {{mimeType: webrtc.MimeTypeAV1, clockRate: 90000, payloadType: 45, rtxType: 46}},
+}
+
+// videoRTCPFeedback is the RTCP feedback advertised for every video codec
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: "goog-remb"},
+	{Type: "ccm", Parameter: "fir"},
+	{Type: "nack"},
+	{Type: "nack", Parameter: "pli"},
+}
+
+// opusFmtp builds the Opus fmtp line from the codec config
+func opusFmtp(cfg CodecConfig) string {
+	params := []string{"minptime=10"}
+	if cfg.OpusFEC {
+		params = append(params, "useinbandfec=1")
+	}
+	if cfg.OpusStereo {
+		params = append(params, "stereo=1", "sprop-stereo=1")
+	}
+	if cfg.OpusDTX {
+		params = append(params, "usedtx=1")
+	}
+	if cfg.OpusMaxAverageBitrate > 0 {
+		params = append(params, fmt.Sprintf("maxaveragebitrate=%d", cfg.OpusMaxAverageBitrate))
+	}
+	return strings.Join(params, ";")
+}
+
+// registerCodecs registers only the allowed codecs with the MediaEngine, in order of preference
+func registerCodecs(mediaEngine *webrtc.MediaEngine, cfg CodecConfig) error {
+	if len(cfg.AudioCodecs) == 0 && len(cfg.VideoCodecs) == 0 {
+		return fmt.Errorf("no audio or video codecs allowed")
+	}
+
+	for _, name := range cfg.AudioCodecs {
+		entries, ok := audioCodecs[name]
+		if !ok {
+			return fmt.Errorf("unknown audio codec %q", name)
+		}
+		for _, entry := range entries {
+			if entry.mimeType == webrtc.MimeTypeOpus {
+				entry.fmtp = opusFmtp(cfg)
+			}
+			if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					MimeType:    entry.mimeType,
+					ClockRate:   entry.clockRate,
+					Channels:    entry.channels,
+					SDPFmtpLine: entry.fmtp,
+				},
+				PayloadType: entry.payloadType,
+			}, webrtc.RTPCodecTypeAudio); err != nil {
+				return fmt.Errorf("failed to register audio codec %q: %w", name, err)
+			}
+		}
+	}
+
+	for _, name := range cfg.VideoCodecs {
+		entries, ok := videoCodecs[name]
+		if !ok {
+			return fmt.Errorf("unknown video codec %q", name)
+		}
+		for _, entry := range entries {
+			if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					MimeType:     entry.mimeType,
+					ClockRate:    entry.clockRate,
+					SDPFmtpLine:  entry.fmtp,
+					RTCPFeedback: videoRTCPFeedback,
+				},
+				PayloadType: entry.payloadType,
+			}, webrtc.RTPCodecTypeVideo); err != nil {
+				return fmt.Errorf("failed to register video codec %q: %w", name, err)
+			}
+			if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					MimeType:    "video/rtx",
+					ClockRate:   entry.clockRate,
+					SDPFmtpLine: fmt.Sprintf("apt=%d", entry.payloadType),
+				},
+				PayloadType: entry.rtxType,
+			}, webrtc.RTPCodecTypeVideo); err != nil {
+				return fmt.Errorf("failed to register RTX for video codec %q: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
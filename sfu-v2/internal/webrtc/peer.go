@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
@@ -23,16 +24,24 @@ type PeerConnection struct {
 // Manager handles multiple peer connections per room
 type Manager struct {
 	mu sync.RWMutex
-	// Map of roomID -> clientID -> PeerConnection
+	// Map of roomID -> userID -> PeerConnection
 	roomPeers map[string]map[string]PeerConnection
-	debug     bool
+	// api is shared by all peer connections so they use the same ICE settings and muxes
+	api   *API
+	debug bool
+
+	// estimators holds the downstream bandwidth estimator of each peer connection
+	estimatorsMu sync.RWMutex
+	estimators   map[*webrtc.PeerConnection]cc.BandwidthEstimator
 }
 
 // NewManager creates a new WebRTC peer connection manager
-func NewManager(debug bool) *Manager {
+func NewManager(debug bool, api *API) *Manager {
 	return &Manager{
-		roomPeers: make(map[string]map[string]PeerConnection),
-		debug:     debug,
+		roomPeers:  make(map[string]map[string]PeerConnection),
+		api:        api,
+		debug:      debug,
+		estimators: make(map[*webrtc.PeerConnection]cc.BandwidthEstimator),
 	}
 }
 
@@ -44,7 +53,7 @@ func (m *Manager) debugLog(format string, args ...interface{}) {
 }
 
 // AddPeerToRoom adds a new peer connection to a specific room
-func (m *Manager) AddPeerToRoom(roomID, clientID string, pc *webrtc.PeerConnection, ws WebSocketWriter) {
+func (m *Manager) AddPeerToRoom(roomID, userID string, pc *webrtc.PeerConnection, ws WebSocketWriter) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -55,17 +64,18 @@ func (m *Manager) AddPeerToRoom(roomID, clientID string, pc *webrtc.PeerConnecti
 	}
 
 	// Add peer to room
-	m.roomPeers[roomID][clientID] = PeerConnection{
+	m.roomPeers[roomID][userID] = PeerConnection{
 		PC:        pc,
 		WebSocket: ws,
 	}
 
 	roomPeerCount := len(m.roomPeers[roomID])
-	m.debugLog("🔗 Added peer '%s' to room '%s' (Room peers: %d)", clientID, roomID, roomPeerCount)
+	m.debugLog("🔗 Added peer '%s' to room '%s' (Room peers: %d)", userID, roomID, roomPeerCount)
 }
 
-// RemovePeerFromRoom removes a peer connection from a specific room
-func (m *Manager) RemovePeerFromRoom(roomID, clientID string) {
+// RemovePeerFromRoom removes a peer connection from a specific room.
+// Nothing is removed if the user's entry belongs to a different (newer) peer connection.
+func (m *Manager) RemovePeerFromRoom(roomID, userID string, pc *webrtc.PeerConnection) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -75,13 +85,19 @@ func (m *Manager) RemovePeerFromRoom(roomID, clientID string) {
 		return
 	}
 
-	if _, peerExists := roomPeers[clientID]; !peerExists {
-		m.debugLog("❌ Peer '%s' not found in room '%s'", clientID, roomID)
+	peer, peerExists := roomPeers[userID]
+	if !peerExists {
+		m.debugLog("❌ Peer '%s' not found in room '%s'", userID, roomID)
+		return
+	}
+
+	if peer.PC != pc {
+		m.debugLog("⚠️ Peer '%s' in room '%s' belongs to a newer session, keeping it", userID, roomID)
 		return
 	}
 
-	delete(roomPeers, clientID)
-	m.debugLog("🗑️  Removed peer '%s' from room '%s' (Remaining peers: %d)", clientID, roomID, len(roomPeers))
+	delete(roomPeers, userID)
+	m.debugLog("🗑️  Removed peer '%s' from room '%s' (Remaining peers: %d)", userID, roomID, len(roomPeers))
 
 	// Clean up empty room
 	if len(roomPeers) == 0 {
@@ -90,6 +106,15 @@ func (m *Manager) RemovePeerFromRoom(roomID, clientID string) {
 	}
 }
 
+// GetPeer returns the peer connection of a user in a specific room
+func (m *Manager) GetPeer(roomID, userID string) (PeerConnection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	peer, exists := m.roomPeers[roomID][userID]
+	return peer, exists
+}
+
 // GetPeersInRoom returns a copy of all peer connections in a specific room
 func (m *Manager) GetPeersInRoom(roomID string) []PeerConnection {
 	m.mu.RLock()
@@ -153,19 +178,18 @@ func (m *Manager) DispatchKeyFrameToRoom(roomID string) {
 	keyframesSent := 0
 	for clientID, peer := range roomPeers {
 		for _, receiver := range peer.PC.GetReceivers() {
-			if receiver.Track() == nil {
-				continue
-			}
-
-			// Send a Picture Loss Indication (PLI) to request a keyframe
-			if err := peer.PC.WriteRTCP([]rtcp.Packet{
-				&rtcp.PictureLossIndication{
-					MediaSSRC: uint32(receiver.Track().SSRC()),
-				},
-			}); err != nil {
-				m.debugLog("❌ Error sending keyframe to peer '%s' in room '%s': %v", clientID, roomID, err)
-			} else {
-				keyframesSent++
+			// Simulcast receivers carry one track per layer
+			for _, remoteTrack := range receiver.Tracks() {
+				// Send a Picture Loss Indication (PLI) to request a keyframe
+				if err := peer.PC.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{
+						MediaSSRC: uint32(remoteTrack.SSRC()),
+					},
+				}); err != nil {
+					m.debugLog("❌ Error sending keyframe to peer '%s' in room '%s': %v", clientID, roomID, err)
+				} else {
+					keyframesSent++
+				}
 			}
 		}
 	}
@@ -210,18 +234,29 @@ func (m *Manager) StartKeyFrameDispatcher() {
 	}()
 }
 
-// CreatePeerConnection creates a new WebRTC peer connection with the given configuration
-func CreatePeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection, error) {
-	peerConnection, err := webrtc.NewPeerConnection(config)
+// CreatePeerConnection creates a new WebRTC peer connection with the given configuration.
+// ReleasePeerConnection must be called once the peer connection is closed.
+func (m *Manager) CreatePeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	peerConnection, estimator, err := m.api.NewPeerConnection(config)
 	if err != nil {
 		return nil, err
 	}
 
+	if estimator != nil {
+		m.estimatorsMu.Lock()
+		m.estimators[peerConnection] = estimator
+		m.estimatorsMu.Unlock()
+	}
+
 	// Prepare to receive both audio and video tracks from clients
 	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
 		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
 			Direction: webrtc.RTPTransceiverDirectionRecvonly,
 		}); err != nil {
+			if closeErr := peerConnection.Close(); closeErr != nil {
+				m.debugLog("❌ Error closing peer connection: %v", closeErr)
+			}
+			m.ReleasePeerConnection(peerConnection)
 			return nil, err
 		}
 	}
@@ -229,6 +264,26 @@ func CreatePeerConnection(config webrtc.Configuration) (*webrtc.PeerConnection,
 	return peerConnection, nil
 }
 
+// ReleasePeerConnection drops the state kept for a closed peer connection
+func (m *Manager) ReleasePeerConnection(pc *webrtc.PeerConnection) {
+	m.estimatorsMu.Lock()
+	defer m.estimatorsMu.Unlock()
+	delete(m.estimators, pc)
+}
+
+// EstimatedBitrate returns the estimated downstream bandwidth of a peer connection in
+// bits per second, or zero if there is no estimate
+func (m *Manager) EstimatedBitrate(pc *webrtc.PeerConnection) int {
+	m.estimatorsMu.RLock()
+	estimator, exists := m.estimators[pc]
+	m.estimatorsMu.RUnlock()
+
+	if !exists {
+		return 0
+	}
+	return estimator.GetTargetBitrate()
+}
+
 // Legacy methods for backward compatibility (deprecated)
 func (m *Manager) AddPeer(pc *webrtc.PeerConnection, ws WebSocketWriter) {
 	m.debugLog("⚠️  Warning: AddPeer() is deprecated, use AddPeerToRoom() instead")
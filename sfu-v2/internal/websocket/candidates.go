@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/pkg/types"
+)
+
+// maxBufferedCandidates caps the remote candidates held for a peer before its remote description is set
+const maxBufferedCandidates = 64
+
+// candidateBuffer holds the remote ICE candidates of one peer that arrived before the
+// matching remote description. It is only used from the peer's message loop.
+type candidateBuffer struct {
+	pending []webrtc.ICECandidateInit
+}
+
+// candidateCounters counts remote ICE candidates across all peers
+type candidateCounters struct {
+	added    atomic.Uint64
+	buffered atomic.Uint64
+	flushed  atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// addRemoteCandidate adds a remote candidate to a peer connection, or buffers it if the
+// remote description is not set yet. An empty candidate signals the end of candidates.
+func (h *Handler) addRemoteCandidate(peerConnection *webrtc.PeerConnection, buffer *candidateBuffer, candidate webrtc.ICECandidateInit, userID string) error {
+	if peerConnection.RemoteDescription() == nil {
+		if len(buffer.pending) >= maxBufferedCandidates {
+			h.candidateStats.dropped.Add(1)
+			h.debugLog("⚠️ Dropping early ICE candidate from %s, %d already buffered", userID, len(buffer.pending))
+			return nil
+		}
+
+		buffer.pending = append(buffer.pending, candidate)
+		h.candidateStats.buffered.Add(1)
+		h.debugLog("📥 Buffering ICE candidate from %s until the remote description is set (%d buffered)", userID, len(buffer.pending))
+		return nil
+	}
+
+	if err := peerConnection.AddICECandidate(candidate); err != nil {
+		h.candidateStats.failed.Add(1)
+		h.debugLog("❌ Error adding ICE candidate from %s: %v", userID, err)
+		return err
+	}
+	h.candidateStats.added.Add(1)
+	return nil
+}
+
+// flushCandidates adds the buffered candidates of a peer once its remote description is set
+func (h *Handler) flushCandidates(peerConnection *webrtc.PeerConnection, buffer *candidateBuffer, userID string) {
+	if len(buffer.pending) == 0 || peerConnection.RemoteDescription() == nil {
+		return
+	}
+
+	h.debugLog("📤 Flushing %d buffered ICE candidates from %s", len(buffer.pending), userID)
+	for _, candidate := range buffer.pending {
+		if err := peerConnection.AddICECandidate(candidate); err != nil {
+			h.candidateStats.failed.Add(1)
+			h.debugLog("❌ Error adding buffered ICE candidate from %s: %v", userID, err)
+			continue
+		}
+		h.candidateStats.flushed.Add(1)
+	}
+	buffer.pending = nil
+}
+
+// discardCandidates drops the candidates still buffered when a peer's session ends
+func (h *Handler) discardCandidates(buffer *candidateBuffer, userID string) {
+	if len(buffer.pending) == 0 {
+		return
+	}
+
+	h.debugLog("🗑️  Discarding %d buffered ICE candidates from %s", len(buffer.pending), userID)
+	h.candidateStats.dropped.Add(uint64(len(buffer.pending)))
+	buffer.pending = nil
+}
+
+// ICECandidateStats returns the remote ICE candidate counters
+func (h *Handler) ICECandidateStats() types.ICECandidateStats {
+	return types.ICECandidateStats{
+		Added:    h.candidateStats.added.Load(),
+		Buffered: h.candidateStats.buffered.Load(),
+		Flushed:  h.candidateStats.flushed.Load(),
+		Dropped:  h.candidateStats.dropped.Load(),
+		Failed:   h.candidateStats.failed.Load(),
+	}
+}
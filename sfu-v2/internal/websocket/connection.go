@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -10,6 +11,11 @@ import (
 type ThreadSafeWriter struct {
 	*websocket.Conn
 	sync.Mutex
+
+	// Protocol state negotiated in the hello handshake
+	protocolMu      sync.RWMutex
+	protocolVersion int
+	features        map[string]bool
 }
 
 // WriteJSON writes a JSON message to the WebSocket connection in a thread-safe manner
@@ -19,6 +25,41 @@ func (t *ThreadSafeWriter) WriteJSON(v interface{}) error {
 	return t.Conn.WriteJSON(v)
 }
 
+// CloseWithReason sends a close frame with the given code and reason, then closes the connection
+func (t *ThreadSafeWriter) CloseWithReason(code int, reason string) error {
+	// WriteControl may be called concurrently with other write methods
+	t.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	return t.Conn.Close()
+}
+
+// SetProtocol records the protocol version and features negotiated in the hello handshake
+func (t *ThreadSafeWriter) SetProtocol(version int, features []string) {
+	t.protocolMu.Lock()
+	defer t.protocolMu.Unlock()
+
+	t.protocolVersion = version
+	t.features = make(map[string]bool, len(features))
+	for _, feature := range features {
+		t.features[feature] = true
+	}
+}
+
+// ProtocolVersion returns the negotiated protocol version, zero if the peer skipped the handshake
+func (t *ThreadSafeWriter) ProtocolVersion() int {
+	t.protocolMu.RLock()
+	defer t.protocolMu.RUnlock()
+	return t.protocolVersion
+}
+
+// Supports reports whether the peer on this connection understands an optional feature.
+// Peers that skipped the handshake speak the original protocol and support none of them.
+func (t *ThreadSafeWriter) Supports(feature string) bool {
+	t.protocolMu.RLock()
+	defer t.protocolMu.RUnlock()
+
+	return t.features[feature]
+}
+
 // NewThreadSafeWriter creates a new thread-safe WebSocket writer
 func NewThreadSafeWriter(conn *websocket.Conn) *ThreadSafeWriter {
 	return &ThreadSafeWriter{
@@ -0,0 +1,22 @@
+package websocket
+
+import (
+	"testing"
+
+	"sfu-v2/pkg/types"
+)
+
+func TestSupports(t *testing.T) {
+	legacy := &ThreadSafeWriter{}
+	for _, feature := range append(clientFeatures, serverFeatures...) {
+		if legacy.Supports(feature) {
+			t.Errorf("peer without a handshake supports %s", feature)
+		}
+	}
+
+	negotiated := &ThreadSafeWriter{}
+	negotiated.SetProtocol(types.ProtocolVersion, []string{types.FeatureTypedErrors})
+	if !negotiated.Supports(types.FeatureTypedErrors) || negotiated.Supports(types.FeaturePeerEvents) {
+		t.Error("negotiated peer does not support exactly the negotiated features")
+	}
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"errors"
+
+	"sfu-v2/internal/auth"
+	"sfu-v2/internal/room"
+	"sfu-v2/internal/track"
+	"sfu-v2/pkg/types"
+)
+
+// retryHint describes whether a failure is worth retrying and how long to wait first
+type retryHint struct {
+	retryable  bool
+	retryAfter int // seconds
+}
+
+// roomErrorRetryHints lists the retry behaviour of each room_error code. Codes not listed are not retryable.
+var roomErrorRetryHints = map[string]retryHint{
+	types.RoomErrorTokenExpired:        {retryable: true},
+	types.RoomErrorServerNotRegistered: {retryable: true, retryAfter: 5},
+	types.RoomErrorServerUnavailable:   {retryable: true, retryAfter: 10},
+	types.RoomErrorDuplicateSession:    {retryable: true, retryAfter: 5},
+	types.RoomErrorRoomFull:            {retryable: true, retryAfter: 30},
+	types.RoomErrorServerCapacity:      {retryable: true, retryAfter: 30},
+	types.RoomErrorInternal:            {retryable: true, retryAfter: 2},
+}
+
+// roomErrorCodes maps the sentinel errors of the room, track and auth packages to room_error codes
+var roomErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{room.ErrServerNotRegistered, types.RoomErrorServerNotRegistered},
+	{room.ErrServerNotTrusted, types.RoomErrorUnauthorized},
+	{room.ErrServerUnavailable, types.RoomErrorServerUnavailable},
+	{room.ErrInvalidCredentials, types.RoomErrorUnauthorized},
+	{room.ErrInvalidRequest, types.RoomErrorInvalidRequest},
+	{room.ErrRoomNotFound, types.RoomErrorRoomNotFound},
+	{room.ErrRoomOwnership, types.RoomErrorRoomConflict},
+	{room.ErrDuplicateSession, types.RoomErrorDuplicateSession},
+	{room.ErrUserNotInRoom, types.RoomErrorUserNotFound},
+	{room.ErrRoomFull, types.RoomErrorRoomFull},
+	{room.ErrServerAtCapacity, types.RoomErrorServerCapacity},
+	{track.ErrTrackLimit, types.RoomErrorTrackLimit},
+	{track.ErrTrackConflict, types.RoomErrorInvalidRequest},
+	{auth.ErrInvalidToken, types.RoomErrorUnauthorized},
+	{auth.ErrTokenExpired, types.RoomErrorTokenExpired},
+}
+
+// newRoomError creates a room_error payload with the retry hints of its code
+func newRoomError(code, message string) *types.RoomErrorData {
+	hint := roomErrorRetryHints[code]
+	return &types.RoomErrorData{
+		Code:       code,
+		Message:    message,
+		Retryable:  hint.retryable,
+		RetryAfter: hint.retryAfter,
+	}
+}
+
+// roomErrorFor creates a room_error payload for a failure, deriving its code from err.
+// Errors without a known cause are reported as internal errors.
+func roomErrorFor(err error, message string) *types.RoomErrorData {
+	for _, mapping := range roomErrorCodes {
+		if errors.Is(err, mapping.err) {
+			return newRoomError(mapping.code, message)
+		}
+	}
+	return newRoomError(types.RoomErrorInternal, message)
+}
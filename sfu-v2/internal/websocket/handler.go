@@ -3,14 +3,21 @@ package websocket
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 
+	"sfu-v2/internal/auth"
 	"sfu-v2/internal/config"
 	"sfu-v2/internal/recovery"
 	"sfu-v2/internal/room"
@@ -24,11 +31,26 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// sessionCloseCodes maps session_closed reasons to the WebSocket close codes sent with them
+var sessionCloseCodes = map[string]int{
+	types.SessionCloseReasonReplaced:   4001,
+	types.SessionCloseReasonKicked:     4002,
+	types.SessionCloseReasonRoomClosed: 4003,
+	types.SessionCloseReasonServerGone: 4004,
+}
+
+// defaultSessionCloseCode is used for reasons without a dedicated close code
+const defaultSessionCloseCode = 4000
+
 // Coordinator interface to avoid circular imports
 type Coordinator interface {
 	SignalPeerConnectionsInRoom(roomID string)
 	OnTrackAddedToRoom(roomID string)
 	OnTrackRemovedFromRoom(roomID string)
+	LockNegotiation(peerConnection *webrtc.PeerConnection) func()
+	ReleasePeer(peerConnection *webrtc.PeerConnection)
+	OnSignalingStable(peerConnection *webrtc.PeerConnection)
+	UpdateSubscriptions(peerConnection *webrtc.PeerConnection, roomID string, subscribe bool, userIDs, trackIDs []string) error
 }
 
 // Handler manages WebSocket connections and integrates with other components
@@ -38,6 +60,14 @@ type Handler struct {
 	webrtcManager *peerManager.Manager
 	roomManager   *room.Manager
 	coordinator   Coordinator
+	nonceCache    *auth.NonceCache
+
+	// candidateStats counts remote ICE candidates, including those dropped for arriving too early
+	candidateStats candidateCounters
+
+	// serverConns maps registered server IDs to the /server connection that registered them
+	serverConns map[string]*ThreadSafeWriter
+	serversMu   sync.RWMutex
 }
 
 // NewHandler creates a new WebSocket handler
@@ -48,6 +78,8 @@ func NewHandler(cfg *config.Config, trackManager *track.Manager, webrtcManager *
 		webrtcManager: webrtcManager,
 		roomManager:   roomManager,
 		coordinator:   coordinator,
+		nonceCache:    auth.NewNonceCache(),
+		serverConns:   make(map[string]*ThreadSafeWriter),
 	}
 }
 
@@ -113,6 +145,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleServerConnection(conn *ThreadSafeWriter, clientID string) error {
 	return recovery.SafeExecuteWithContext("WEBSOCKET", "HANDLE_SERVER", clientID, "", "Server connection handling", func() error {
 		h.debugLog("🖥️  Server connection established: %s", clientID)
+		defer h.handleServerDisconnect(conn)
 
 		// Handle server registration messages
 		for {
@@ -138,11 +171,33 @@ func (h *Handler) handleServerConnection(conn *ThreadSafeWriter, clientID string
 
 			h.debugLog("📨 Server message from %s: event=%s", clientID, message.Event)
 
+			// A failed handshake ends the connection
+			if message.Event == types.EventHello {
+				if err := h.handleHello(conn, clientID, message.Data, serverFeatures); err != nil {
+					return err
+				}
+				continue
+			}
+
 			// Process server message with recovery
 			err = recovery.SafeExecuteWithContext("WEBSOCKET", "PROCESS_SERVER_MESSAGE", clientID, "", message.Event, func() error {
 				switch message.Event {
 				case types.EventServerRegister:
 					return h.handleServerRegistration(conn, clientID, message.Data)
+				case types.EventUserAudioControl:
+					return h.handleUserAudioControl(conn, clientID, message.Data)
+				case types.EventRoomSnapshot:
+					return h.handleRoomSnapshot(conn, clientID, message.Data)
+				case types.EventKickPeer:
+					return h.handleKickPeer(conn, clientID, message.Data)
+				case types.EventCloseRoom:
+					return h.handleCloseRoom(conn, clientID, message.Data)
+				case types.EventRoomUnregister:
+					return h.handleRoomUnregister(conn, clientID, message.Data)
+				case types.EventServerUnregister:
+					return h.handleServerUnregister(conn, clientID, message.Data)
+				case types.EventRotateCredentials:
+					return h.handleRotateCredentials(conn, clientID, message.Data)
 				case types.EventKeepAlive:
 					// Keep-alive message from server to prevent connection timeouts - no action needed
 					// Only log in debug mode to avoid spam
@@ -169,23 +224,68 @@ func (h *Handler) handleServerRegistration(conn *ThreadSafeWriter, clientID, dat
 	var regData types.ServerRegistrationData
 	if err := recovery.SafeJSONUnmarshal([]byte(data), &regData); err != nil {
 		h.debugLog("❌ Error unmarshalling server registration data from %s: %v", clientID, err)
-		h.sendErrorToConnection(conn, "Invalid registration data")
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid registration data"))
 		return err
 	}
 
 	h.debugLog("🖥️  Server registration attempt: ServerID=%s, RoomID=%s", regData.ServerID, regData.RoomID)
 
-	if err := h.roomManager.RegisterServer(regData.ServerID, regData.ServerPassword, regData.RoomID); err != nil {
+	joinTokenKey, err := h.roomManager.RegisterServer(regData.ServerID, regData.ServerPassword, regData.RoomID, room.RoomLimits{
+		MaxPeers:  regData.MaxPeersPerRoom,
+		MaxTracks: regData.MaxTracksPerRoom,
+		LastN:     regData.LastN,
+	})
+	if err != nil {
 		h.debugLog("❌ Server registration failed for %s: %v", regData.ServerID, err)
-		h.sendErrorToConnection(conn, "Registration failed: "+err.Error())
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Registration failed: "+err.Error()))
 		return err
 	}
 
+	h.bindServerConnection(regData.ServerID, conn)
+
 	h.debugLog("✅ Server %s registered room %s successfully", regData.ServerID, regData.RoomID)
+	h.sendJoinTokenKey(conn, regData.ServerID, joinTokenKey)
 	h.sendSuccessToConnection(conn, "Server registered successfully")
 	return nil
 }
 
+// handleUserAudioControl applies a server-driven mute/deafen update to a user
+func (h *Handler) handleUserAudioControl(conn *ThreadSafeWriter, clientID, data string) error {
+	var controlData types.UserAudioControlData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &controlData); err != nil {
+		h.debugLog("❌ Error unmarshalling audio control data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid audio control data"))
+		return err
+	}
+
+	h.debugLog("🔇 Audio control from server %s: user=%s, room=%s, muted=%t, deafened=%t",
+		controlData.ServerID, controlData.UserID, controlData.RoomID, controlData.IsMuted, controlData.IsDeafened)
+
+	serverID, err := h.authorizeRoomCommand(conn, controlData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Audio control rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Audio control failed: "+err.Error()))
+		return err
+	}
+
+	previous, err := h.roomManager.SetUserAudioState(controlData.RoomID, serverID, controlData.UserID, controlData.IsMuted, controlData.IsDeafened)
+	if err != nil {
+		h.debugLog("❌ Audio control failed for user %s: %v", controlData.UserID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Audio control failed: "+err.Error()))
+		return err
+	}
+
+	if previous.Muted != controlData.IsMuted {
+		h.refreshUserMute(controlData.RoomID, controlData.UserID)
+	}
+
+	// Deafening changes which tracks the user should receive, so renegotiate
+	if previous.Deafened != controlData.IsDeafened {
+		h.coordinator.SignalPeerConnectionsInRoom(controlData.RoomID)
+	}
+	return nil
+}
+
 // handleClientConnection handles client WebRTC connections
 func (h *Handler) handleClientConnection(conn *ThreadSafeWriter, clientID string, r *http.Request) error {
 	return recovery.SafeExecuteWithContext("WEBSOCKET", "HANDLE_CLIENT", clientID, "", "Client connection handling", func() error {
@@ -213,47 +313,77 @@ func (h *Handler) handleClientConnection(conn *ThreadSafeWriter, clientID string
 
 		h.debugLog("📨 Client initial message from %s: event=%s", clientID, message.Event)
 
+		// Clients may open with a hello handshake before joining. Clients that
+		// skip it are treated as legacy clients.
+		if message.Event == types.EventHello {
+			if err := h.handleHello(conn, clientID, message.Data, clientFeatures); err != nil {
+				return err
+			}
+
+			err = recovery.SafeExecuteWithContext("WEBSOCKET", "READ_CLIENT_JOIN", clientID, "", "Reading client join message", func() error {
+				_, raw, err = conn.ReadMessage()
+				return err
+			})
+			if err != nil {
+				h.debugLog("❌ Error reading client join message from %s: %v", clientID, err)
+				return err
+			}
+
+			message = &types.WebSocketMessage{}
+			if err := recovery.SafeJSONUnmarshal(raw, &message); err != nil {
+				h.debugLog("❌ Error unmarshalling client join message from %s: %v", clientID, err)
+				return err
+			}
+		}
+
 		if message.Event != types.EventClientJoin {
 			h.debugLog("❌ Expected client_join event from %s, got: %s", clientID, message.Event)
-			h.sendErrorToConnection(conn, "Expected client_join event")
+			h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Expected client_join event"))
 			return fmt.Errorf("expected client_join event, got: %s", message.Event)
 		}
 
 		var joinData types.ClientJoinData
 		if err := recovery.SafeJSONUnmarshal([]byte(message.Data), &joinData); err != nil {
 			h.debugLog("❌ Error unmarshalling client join data from %s: %v", clientID, err)
-			h.sendErrorToConnection(conn, "Invalid join data")
+			h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid join data"))
 			return err
 		}
 
 		h.debugLog("👤 Client %s attempting to join room '%s' (Server: %s)", clientID, joinData.RoomID, joinData.ServerID)
 
 		// Validate client can join the room
-		if err := h.roomManager.ValidateClientJoin(joinData.RoomID, joinData.ServerID, joinData.ServerPassword); err != nil {
+		userID, err := h.authorizeClientJoin(&joinData)
+		if err != nil {
 			h.debugLog("❌ Client join validation failed for %s: %v", clientID, err)
-			h.sendErrorToConnection(conn, "Join validation failed: "+err.Error())
+			h.sendErrorToConnection(conn, roomErrorFor(err, "Join validation failed: "+err.Error()))
 			return err
 		}
 
-		h.debugLog("✅ Client %s validated for room '%s'", clientID, joinData.RoomID)
+		// The verified user ID is the peer's identity from here on. Password joins
+		// have no verified user ID and are bound to the connection ID.
+		if userID == "" {
+			userID = clientID
+		}
+
+		h.debugLog("✅ Client %s validated for room '%s'", userID, joinData.RoomID)
 
 		// Create WebRTC peer connection with recovery
 		var peerConnection *webrtc.PeerConnection
-		err = recovery.SafeExecuteWithContext("WEBSOCKET", "CREATE_PEER_CONNECTION", clientID, joinData.RoomID, "Creating WebRTC peer connection", func() error {
+		err = recovery.SafeExecuteWithContext("WEBSOCKET", "CREATE_PEER_CONNECTION", userID, joinData.RoomID, "Creating WebRTC peer connection", func() error {
 			// Create WebRTC configuration
 			config := webrtc.Configuration{
 				ICEServers: h.config.ICEServers,
 			}
 
 			var createErr error
-			peerConnection, createErr = peerManager.CreatePeerConnection(config)
+			peerConnection, createErr = h.webrtcManager.CreatePeerConnection(config)
 			if createErr != nil {
-				h.debugLog("❌ Error creating WebRTC peer connection for %s: %v", clientID, createErr)
-				h.sendErrorToConnection(conn, "Failed to create peer connection")
+				h.debugLog("❌ Error creating WebRTC peer connection for %s: %v", userID, createErr)
+				h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInternal, "Failed to create peer connection"))
 				return createErr
 			}
 
-			h.debugLog("🔗 Created WebRTC peer connection for client %s", clientID)
+			h.debugLog("🔗 Created WebRTC peer connection for client %s", userID)
 			return nil
 		})
 
@@ -263,24 +393,45 @@ func (h *Handler) handleClientConnection(conn *ThreadSafeWriter, clientID string
 
 		// Ensure peer connection cleanup
 		defer func() {
-			recovery.SafeExecuteWithContext("WEBSOCKET", "CLEANUP_PEER_CONNECTION", clientID, joinData.RoomID, "Cleaning up peer connection", func() error {
+			recovery.SafeExecuteWithContext("WEBSOCKET", "CLEANUP_PEER_CONNECTION", userID, joinData.RoomID, "Cleaning up peer connection", func() error {
 				if peerConnection != nil {
 					peerConnection.Close()
+					h.coordinator.ReleasePeer(peerConnection)
+					h.webrtcManager.ReleasePeerConnection(peerConnection)
 				}
 				return nil
 			})
 		}()
 
 		// Add peer to room managers with recovery
-		err = recovery.SafeExecuteWithContext("WEBSOCKET", "ADD_PEER_TO_ROOM", clientID, joinData.RoomID, "Adding peer to room", func() error {
-			if err := h.roomManager.AddPeerToRoom(joinData.RoomID, clientID, peerConnection, conn.Conn); err != nil {
-				h.debugLog("❌ Error adding peer %s to room %s: %v", clientID, joinData.RoomID, err)
-				h.sendErrorToConnection(conn, "Failed to join room")
+		err = recovery.SafeExecuteWithContext("WEBSOCKET", "ADD_PEER_TO_ROOM", userID, joinData.RoomID, "Adding peer to room", func() error {
+			replaced, err := h.roomManager.AddPeerToRoom(joinData.RoomID, userID, peerConnection, conn.Conn)
+			if err != nil {
+				h.debugLog("❌ Error adding peer %s to room %s: %v", userID, joinData.RoomID, err)
+				switch {
+				case errors.Is(err, room.ErrRoomFull):
+					h.sendErrorToConnection(conn, newRoomError(types.RoomErrorRoomFull, "This room is full"))
+				case errors.Is(err, room.ErrServerAtCapacity):
+					h.sendErrorToConnection(conn, newRoomError(types.RoomErrorServerCapacity, "The voice server is at capacity, try again later"))
+				default:
+					h.sendErrorToConnection(conn, roomErrorFor(err, "Failed to join room: "+err.Error()))
+				}
 				return err
 			}
 
+			// Tear down the session this join replaced before registering the new one
+			if replaced != nil {
+				h.debugLog("🔁 Closing previous session of user %s in room '%s'", userID, replaced.RoomID)
+				h.closeSession(replaced.RoomID, userID, replaced.PeerConnection, replaced.Connection,
+					types.SessionCloseReasonReplaced, "Your session was replaced by a new connection")
+				h.notifyPeerEvent(types.EventPeerLeft, joinData.ServerID, replaced.RoomID, userID, types.SessionCloseReasonReplaced)
+				if replaced.RoomID != joinData.RoomID {
+					h.coordinator.SignalPeerConnectionsInRoom(replaced.RoomID)
+				}
+			}
+
 			// Also add to WebRTC manager for keyframe dispatch
-			h.webrtcManager.AddPeerToRoom(joinData.RoomID, clientID, peerConnection, conn)
+			h.webrtcManager.AddPeerToRoom(joinData.RoomID, userID, peerConnection, conn)
 			return nil
 		})
 
@@ -290,48 +441,122 @@ func (h *Handler) handleClientConnection(conn *ThreadSafeWriter, clientID string
 
 		// Remove peer from both managers on disconnect
 		defer func() {
-			recovery.SafeExecuteWithContext("WEBSOCKET", "REMOVE_PEER_FROM_ROOM", clientID, joinData.RoomID, "Removing peer from room", func() error {
-				h.debugLog("🚪 Client %s leaving room '%s'", clientID, joinData.RoomID)
-				h.roomManager.RemovePeerFromRoom(joinData.RoomID, clientID)
-				h.webrtcManager.RemovePeerFromRoom(joinData.RoomID, clientID)
+			recovery.SafeExecuteWithContext("WEBSOCKET", "REMOVE_PEER_FROM_ROOM", userID, joinData.RoomID, "Removing peer from room", func() error {
+				h.debugLog("🚪 Client %s leaving room '%s'", userID, joinData.RoomID)
+				removed, _ := h.roomManager.RemovePeerFromRoom(joinData.RoomID, userID, peerConnection)
+				h.webrtcManager.RemovePeerFromRoom(joinData.RoomID, userID, peerConnection)
+				if removed {
+					h.notifyPeerEvent(types.EventPeerLeft, joinData.ServerID, joinData.RoomID, userID, "")
+				}
 				h.coordinator.SignalPeerConnectionsInRoom(joinData.RoomID)
 				return nil
 			})
 		}()
 
 		// Send success message
-		h.debugLog("✅ Client %s successfully joined room '%s'", clientID, joinData.RoomID)
-		h.sendSuccessToConnection(conn, "Successfully joined room")
+		h.debugLog("✅ Client %s successfully joined room '%s'", userID, joinData.RoomID)
+		if conn.Supports(types.FeatureICEServers) {
+			h.sendRoomJoinedToConnection(conn, &types.RoomJoinedData{
+				RoomID:     joinData.RoomID,
+				UserID:     userID,
+				Message:    "Successfully joined room",
+				ICEServers: h.iceServersFor(userID),
+			})
+		} else {
+			h.sendSuccessToConnection(conn, "Successfully joined room")
+		}
+		h.notifyPeerEvent(types.EventPeerJoined, joinData.ServerID, joinData.RoomID, userID, "")
 
 		// Set up WebRTC event handlers with recovery
-		h.setupWebRTCHandlers(peerConnection, conn, clientID, joinData.RoomID)
+		h.setupWebRTCHandlers(peerConnection, conn, userID, joinData.RoomID, joinData.ServerID)
 
 		// Signal the new peer connection to start the negotiation process
-		recovery.SafeExecuteWithContext("WEBSOCKET", "SIGNAL_PEER_CONNECTIONS", clientID, joinData.RoomID, "Starting peer signaling", func() error {
-			h.debugLog("🔄 Starting peer connection signaling for %s in room '%s'", clientID, joinData.RoomID)
+		recovery.SafeExecuteWithContext("WEBSOCKET", "SIGNAL_PEER_CONNECTIONS", userID, joinData.RoomID, "Starting peer signaling", func() error {
+			h.debugLog("🔄 Starting peer connection signaling for %s in room '%s'", userID, joinData.RoomID)
 			h.coordinator.SignalPeerConnectionsInRoom(joinData.RoomID)
 			return nil
 		})
 
 		// Handle incoming WebSocket messages from the client
-		return h.handleClientMessages(conn, peerConnection, joinData.RoomID, clientID)
+		return h.handleClientMessages(conn, peerConnection, joinData.RoomID, userID)
 	})
 }
 
+// authorizeClientJoin validates a client join request and returns the joining user's ID.
+// Signed join tokens are verified against the issuing server's key and may only be used once.
+// Password joins carry no verified identity and return an empty user ID.
+func (h *Handler) authorizeClientJoin(joinData *types.ClientJoinData) (string, error) {
+	if !auth.IsSignedJoinToken(joinData.UserToken) {
+		if !h.config.AllowPasswordJoin {
+			return "", fmt.Errorf("%w: a signed join token is required", auth.ErrInvalidToken)
+		}
+		if err := h.roomManager.ValidateClientJoin(joinData.RoomID, joinData.ServerID, joinData.ServerPassword); err != nil {
+			return "", err
+		}
+		// The unsigned token is controlled by the client, so it cannot name the user
+		return "", nil
+	}
+
+	unverified, err := auth.ParseJoinToken(joinData.UserToken)
+	if err != nil {
+		return "", err
+	}
+
+	keys, exists := h.roomManager.JoinTokenKeys(unverified.ServerID)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", room.ErrServerNotRegistered, unverified.ServerID)
+	}
+
+	now := time.Now()
+	var claims *auth.JoinClaims
+	for _, key := range keys {
+		if claims, err = auth.VerifyJoinToken(joinData.UserToken, key, now); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if claims.RoomID != joinData.RoomID {
+		return "", fmt.Errorf("%w: not valid for room %s", auth.ErrInvalidToken, joinData.RoomID)
+	}
+
+	if err := h.roomManager.ValidateTokenJoin(claims.RoomID, claims.ServerID); err != nil {
+		return "", err
+	}
+
+	// Consume the nonce only once the join is allowed, so a rejected join can be retried with the same token
+	if !h.nonceCache.Use(claims.Nonce, claims.Expiry(), now) {
+		return "", fmt.Errorf("%w: already used", auth.ErrInvalidToken)
+	}
+
+	// The token is authoritative for which server the client belongs to
+	joinData.ServerID = claims.ServerID
+	return claims.UserID, nil
+}
+
 // setupWebRTCHandlers sets up WebRTC event handlers with crash protection
-func (h *Handler) setupWebRTCHandlers(peerConnection *webrtc.PeerConnection, conn *ThreadSafeWriter, clientID, roomID string) {
+func (h *Handler) setupWebRTCHandlers(peerConnection *webrtc.PeerConnection, conn *ThreadSafeWriter, userID, roomID, serverID string) {
 	// Set up ICE candidate handling with recovery
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
-		recovery.SafeExecuteWithContext("WEBRTC", "ICE_CANDIDATE", clientID, roomID, "Handling ICE candidate", func() error {
+		recovery.SafeExecuteWithContext("WEBRTC", "ICE_CANDIDATE", userID, roomID, "Handling ICE candidate", func() error {
+			// A nil candidate means gathering is complete
 			if i == nil {
-				return nil
+				if !conn.Supports(types.FeatureEndOfCandidates) {
+					return nil
+				}
+				h.debugLog("🏁 Sending end of candidates to client %s in room '%s'", userID, roomID)
+				return conn.WriteJSON(&types.WebSocketMessage{
+					Event: types.EventEndOfCandidates,
+				})
 			}
 
-			h.debugLog("🔧 Sending ICE candidate to client %s in room '%s'", clientID, roomID)
+			h.debugLog("🔧 Sending ICE candidate to client %s in room '%s'", userID, roomID)
 
 			candidateString, err := recovery.SafeJSONMarshal(i.ToJSON())
 			if err != nil {
-				h.debugLog("❌ Error marshalling ICE candidate for %s: %v", clientID, err)
+				h.debugLog("❌ Error marshalling ICE candidate for %s: %v", userID, err)
 				return err
 			}
 
@@ -339,213 +564,504 @@ func (h *Handler) setupWebRTCHandlers(peerConnection *webrtc.PeerConnection, con
 				Event: types.EventCandidate,
 				Data:  string(candidateString),
 			}); writeErr != nil {
-				h.debugLog("❌ Error sending candidate JSON to %s: %v", clientID, writeErr)
+				h.debugLog("❌ Error sending candidate JSON to %s: %v", userID, writeErr)
 				return writeErr
 			}
 			return nil
 		})
 	})
 
+	// Re-offer peers that were skipped while they were mid-negotiation
+	peerConnection.OnSignalingStateChange(func(s webrtc.SignalingState) {
+		if s == webrtc.SignalingStateStable {
+			h.coordinator.OnSignalingStable(peerConnection)
+		}
+	})
+
 	// Handle connection state changes with recovery
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
-		recovery.SafeExecuteWithContext("WEBRTC", "CONNECTION_STATE_CHANGE", clientID, roomID, p.String(), func() error {
-			h.debugLog("🔗 Peer connection state change for %s in room '%s': %s", clientID, roomID, p.String())
+		recovery.SafeExecuteWithContext("WEBRTC", "CONNECTION_STATE_CHANGE", userID, roomID, p.String(), func() error {
+			h.debugLog("🔗 Peer connection state change for %s in room '%s': %s", userID, roomID, p.String())
 			switch p {
 			case webrtc.PeerConnectionStateFailed:
-				h.debugLog("❌ Peer connection failed for %s", clientID)
+				h.debugLog("❌ Peer connection failed for %s", userID)
+				h.notifyPeerEvent(types.EventPeerFailed, serverID, roomID, userID, "")
 				if err := peerConnection.Close(); err != nil {
-					h.debugLog("❌ Peer connection failed to close for %s: %v", clientID, err)
+					h.debugLog("❌ Peer connection failed to close for %s: %v", userID, err)
 				}
 			case webrtc.PeerConnectionStateClosed:
-				h.debugLog("🔌 Peer connection closed for %s", clientID)
+				h.debugLog("🔌 Peer connection closed for %s", userID)
 				h.coordinator.SignalPeerConnectionsInRoom(roomID)
 			case webrtc.PeerConnectionStateConnected:
-				h.debugLog("✅ Peer connection established for %s in room '%s'", clientID, roomID)
+				h.debugLog("✅ Peer connection established for %s in room '%s'", userID, roomID)
+				h.notifyPeerEvent(types.EventPeerConnected, serverID, roomID, userID, "")
 			}
 			return nil
 		})
 	})
 
 	// Handle incoming tracks with recovery
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		recovery.SafeExecuteWithContext("WEBRTC", "TRACK_RECEIVED", clientID, roomID, fmt.Sprintf("Track: %s", t.Kind().String()), func() error {
-			h.debugLog("🎵 Incoming track from %s in room '%s': %s (SSRC: %d)", clientID, roomID, t.Kind().String(), t.SSRC())
-
-			// Create a local track to forward the incoming track - now room-specific
-			trackLocal := h.trackManager.AddTrackToRoom(roomID, t)
-			if trackLocal == nil {
-				h.debugLog("❌ Failed to create local track for %s", clientID)
-				return fmt.Errorf("failed to create local track")
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		recovery.SafeExecuteWithContext("WEBRTC", "TRACK_RECEIVED", userID, roomID, fmt.Sprintf("Track: %s", t.Kind().String()), func() error {
+			h.debugLog("🎵 Incoming track from %s in room '%s': %s (SSRC: %d)", userID, roomID, t.Kind().String(), t.SSRC())
+
+			// Keyframe requests for a layer go to the publisher as PLIs
+			requestKeyFrame := func(ssrc webrtc.SSRC) {
+				if err := peerConnection.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)},
+				}); err != nil {
+					h.debugLog("❌ Error requesting keyframe from %s: %v", userID, err)
+				}
+			}
+
+			// Publish the incoming track to the room. Simulcast layers of one track arrive as
+			// separate tracks, told apart by their RID, and join the track added by the first layer.
+			publishedTrack, added, err := h.trackManager.AddTrackToRoom(roomID, userID, t, h.roomManager.RoomLimits(roomID).MaxTracks, requestKeyFrame)
+			if err != nil {
+				h.debugLog("❌ Failed to publish track for %s: %v", userID, err)
+				switch {
+				case errors.Is(err, track.ErrTrackLimit):
+					h.sendErrorToConnection(conn, newRoomError(types.RoomErrorTrackLimit, "Your media could not be published, the room has reached its track limit"))
+				case errors.Is(err, track.ErrTrackConflict):
+					h.sendErrorToConnection(conn, roomErrorFor(err, "Your media could not be published, its track ID is already in use"))
+				}
+				return err
 			}
 
+			rid := t.RID()
 			defer func() {
-				recovery.SafeExecuteWithContext("WEBRTC", "CLEANUP_TRACK", clientID, roomID, "Cleaning up track", func() error {
-					h.trackManager.RemoveTrackFromRoom(roomID, trackLocal)
-					h.coordinator.OnTrackRemovedFromRoom(roomID)
+				recovery.SafeExecuteWithContext("WEBRTC", "CLEANUP_TRACK", userID, roomID, "Cleaning up track", func() error {
+					if h.trackManager.RemoveTrackFromRoom(roomID, publishedTrack, rid) {
+						h.coordinator.OnTrackRemovedFromRoom(roomID)
+					}
 					return nil
 				})
 			}()
 
-			h.debugLog("🎵 Created local track for forwarding from %s", clientID)
+			h.debugLog("🎵 Publishing track %s from %s (RID: %q)", t.ID(), userID, rid)
 
 			// Signal that a new track was added
-			h.coordinator.OnTrackAddedToRoom(roomID)
+			if added {
+				h.coordinator.OnTrackAddedToRoom(roomID)
+			}
+
+			// Audio levels are read from the negotiated ssrc-audio-level extension, if any
+			audioLevelExtensionID := 0
+			for _, extension := range receiver.GetParameters().HeaderExtensions {
+				if extension.URI == sdp.AudioLevelURI {
+					audioLevelExtensionID = extension.ID
+				}
+			}
+			publishedTrack.SetReportsAudioLevels(audioLevelExtensionID != 0)
+
+			// Tracks published while the user is muted start out muted
+			if t.Kind() == webrtc.RTPCodecTypeAudio {
+				h.refreshUserMute(roomID, userID)
+			}
 
 			// Forward RTP packets with recovery
-			return h.forwardRTPPackets(t, trackLocal, clientID)
+			return h.forwardRTPPackets(t, publishedTrack, audioLevelExtensionID, userID, roomID)
 		})
 	})
 }
 
-// forwardRTPPackets forwards RTP packets from remote track to local track
-func (h *Handler) forwardRTPPackets(remoteTrack *webrtc.TrackRemote, localTrack *webrtc.TrackLocalStaticRTP, clientID string) error {
-	buf := make([]byte, 1500)
+// refreshUserMute applies the enforced mute state of a user to the audio tracks they publish
+func (h *Handler) refreshUserMute(roomID, userID string) {
+	h.trackManager.RefreshUserMute(roomID, userID, func() bool {
+		return h.roomManager.GetUserAudioState(roomID, userID).Muted
+	})
+}
+
+// forwardRTPPackets forwards RTP packets from a remote track to the subscribers of its published track.
+// Audio from users muted by the server is dropped instead of forwarded. The audio levels of
+// forwarded packets feed active speaker detection, zero audioLevelExtensionID means none are sent.
+func (h *Handler) forwardRTPPackets(remoteTrack *webrtc.TrackRemote, publishedTrack *track.PublishedTrack, audioLevelExtensionID int, userID, roomID string) error {
 	rtpPacketCount := 0
+	droppedPacketCount := 0
+	isAudio := remoteTrack.Kind() == webrtc.RTPCodecTypeAudio
+	rid := remoteTrack.RID()
+	muted := false
 
 	for {
 		// Read with recovery protection
-		var i int
-		var readErr error
+		var packet *rtp.Packet
 
-		err := recovery.SafeExecuteWithContext("WEBRTC", "READ_RTP_PACKET", clientID, "", "Reading RTP packet", func() error {
-			i, _, readErr = remoteTrack.Read(buf)
+		err := recovery.SafeExecuteWithContext("WEBRTC", "READ_RTP_PACKET", userID, "", "Reading RTP packet", func() error {
+			var readErr error
+			packet, _, readErr = remoteTrack.ReadRTP()
 			return readErr
 		})
 
 		if err != nil {
-			h.debugLog("🎵 Track read ended for %s: %v", clientID, err)
+			h.debugLog("🎵 Track read ended for %s: %v", userID, err)
 			return err
 		}
 
+		// Enforce server-side mute
+		if publishedTrack.Muted() {
+			muted = true
+			droppedPacketCount++
+			if h.config.VerboseLog && droppedPacketCount%1000 == 0 {
+				h.debugLog("🔇 Dropped %d RTP packets from muted client %s", droppedPacketCount, userID)
+			}
+			continue
+		}
+		if muted {
+			// Subscribers continue their streams where the mute started
+			muted = false
+			publishedTrack.Resync()
+		}
+
+		if isAudio && audioLevelExtensionID != 0 {
+			var audioLevel rtp.AudioLevelExtension
+			if extension := packet.GetExtension(uint8(audioLevelExtensionID)); extension != nil && audioLevel.Unmarshal(extension) == nil {
+				h.trackManager.RecordAudioLevel(roomID, userID, audioLevel.Level)
+			}
+		}
+
 		// Write with recovery protection
-		err = recovery.SafeExecuteWithContext("WEBRTC", "WRITE_RTP_PACKET", clientID, "", "Writing RTP packet", func() error {
-			_, writeErr := localTrack.Write(buf[:i])
-			return writeErr
+		err = recovery.SafeExecuteWithContext("WEBRTC", "WRITE_RTP_PACKET", userID, "", "Writing RTP packet", func() error {
+			publishedTrack.WriteRTP(rid, packet)
+			return nil
 		})
 
 		if err != nil {
-			h.debugLog("❌ Track write error for %s: %v", clientID, err)
+			h.debugLog("❌ Track write error for %s: %v", userID, err)
 			return err
 		}
 
 		rtpPacketCount++
 		if h.config.VerboseLog && rtpPacketCount%1000 == 0 {
-			h.debugLog("🎵 Forwarded %d RTP packets from %s", rtpPacketCount, clientID)
+			h.debugLog("🎵 Forwarded %d RTP packets from %s", rtpPacketCount, userID)
 		}
 	}
 }
 
 // handleClientMessages processes incoming WebSocket messages from clients
-func (h *Handler) handleClientMessages(conn *ThreadSafeWriter, peerConnection *webrtc.PeerConnection, roomID, clientID string) error {
-	return recovery.SafeExecuteWithContext("WEBSOCKET", "HANDLE_CLIENT_MESSAGES", clientID, roomID, "Processing client messages", func() error {
-		h.debugLog("📨 Starting message handling for client %s in room '%s'", clientID, roomID)
+func (h *Handler) handleClientMessages(conn *ThreadSafeWriter, peerConnection *webrtc.PeerConnection, roomID, userID string) error {
+	return recovery.SafeExecuteWithContext("WEBSOCKET", "HANDLE_CLIENT_MESSAGES", userID, roomID, "Processing client messages", func() error {
+		h.debugLog("📨 Starting message handling for client %s in room '%s'", userID, roomID)
 
-		message := &types.WebSocketMessage{}
 		messageCount := 0
 
+		// Remote candidates that arrive before the remote description wait here
+		candidates := &candidateBuffer{}
+		defer h.discardCandidates(candidates, userID)
+
 		for {
 			var raw []byte
 			var err error
 
 			// Safe message reading
-			err = recovery.SafeExecuteWithContext("WEBSOCKET", "READ_CLIENT_MESSAGE", clientID, roomID, "Reading client message", func() error {
+			err = recovery.SafeExecuteWithContext("WEBSOCKET", "READ_CLIENT_MESSAGE", userID, roomID, "Reading client message", func() error {
 				_, raw, err = conn.ReadMessage()
 				return err
 			})
 
 			if err != nil {
 				if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-					h.debugLog("🔌 WebSocket closed normally for %s: %v", clientID, err)
+					h.debugLog("🔌 WebSocket closed normally for %s: %v", userID, err)
 					break
 				}
 
-				h.debugLog("❌ Error reading WebSocket message from %s: %v", clientID, err)
+				h.debugLog("❌ Error reading WebSocket message from %s: %v", userID, err)
 				return err
 			}
 
 			messageCount++
 
+			// Decode into a new message, fields missing from this one must not keep earlier values
+			message := &types.WebSocketMessage{}
 			if err := recovery.SafeJSONUnmarshal(raw, &message); err != nil {
-				h.debugLog("❌ Error unmarshalling WebSocket message from %s: %v", clientID, err)
+				h.debugLog("❌ Error unmarshalling WebSocket message from %s: %v", userID, err)
 				continue // Continue processing other messages
 			}
 
-			h.debugLog("📨 Message #%d from %s in room '%s': event=%s", messageCount, clientID, roomID, message.Event)
+			h.debugLog("📨 Message #%d from %s in room '%s': event=%s", messageCount, userID, roomID, message.Event)
 
 			// Process message with recovery
-			err = recovery.SafeExecuteWithContext("WEBSOCKET", "PROCESS_CLIENT_MESSAGE", clientID, roomID, message.Event, func() error {
+			err = recovery.SafeExecuteWithContext("WEBSOCKET", "PROCESS_CLIENT_MESSAGE", userID, roomID, message.Event, func() error {
 				switch message.Event {
 				case types.EventCandidate:
-					return h.handleICECandidate(peerConnection, message.Data, clientID)
+					return h.handleICECandidate(peerConnection, candidates, message.Data, userID)
+				case types.EventEndOfCandidates:
+					h.debugLog("🏁 End of candidates from %s", userID)
+					return h.addRemoteCandidate(peerConnection, candidates, webrtc.ICECandidateInit{}, userID)
 				case types.EventAnswer:
-					return h.handleAnswer(peerConnection, message.Data, clientID)
+					return h.handleAnswer(peerConnection, candidates, message.Data, userID)
+				case types.EventOffer:
+					return h.handleOffer(conn, peerConnection, candidates, message.Data, userID, roomID)
+				case types.EventVideoQuality:
+					return h.handleVideoQuality(message.Data, userID, roomID)
+				case types.EventSubscribe, types.EventUnsubscribe:
+					return h.handleSubscription(peerConnection, message.Data, message.Event == types.EventSubscribe, userID, roomID)
 				case types.EventKeepAlive:
 					// Keep-alive message to prevent connection timeouts - no action needed
 					// Only log in debug mode to avoid spam
 					if h.config.Debug {
-						h.debugLog("💓 Keep-alive received from %s", clientID)
+						h.debugLog("💓 Keep-alive received from %s", userID)
 					}
 					return nil
 				default:
-					h.debugLog("❓ Unknown message event from %s: %s", clientID, message.Event)
+					h.debugLog("❓ Unknown message event from %s: %s", userID, message.Event)
 					return nil
 				}
 			})
 
 			if err != nil {
-				h.debugLog("❌ Error processing message from %s: %v", clientID, err)
+				h.debugLog("❌ Error processing message from %s: %v", userID, err)
 				// Continue processing other messages instead of breaking
 			}
 		}
 
-		h.debugLog("📨 Message handling ended for client %s (Total messages: %d)", clientID, messageCount)
+		h.debugLog("📨 Message handling ended for client %s (Total messages: %d)", userID, messageCount)
 		return nil
 	})
 }
 
 // handleICECandidate processes ICE candidate messages
-func (h *Handler) handleICECandidate(peerConnection *webrtc.PeerConnection, data, clientID string) error {
+func (h *Handler) handleICECandidate(peerConnection *webrtc.PeerConnection, candidates *candidateBuffer, data, userID string) error {
 	candidate := webrtc.ICECandidateInit{}
 	if err := recovery.SafeJSONUnmarshal([]byte(data), &candidate); err != nil {
-		h.debugLog("❌ Error unmarshalling ICE candidate from %s: %v", clientID, err)
+		h.debugLog("❌ Error unmarshalling ICE candidate from %s: %v", userID, err)
 		return err
 	}
 
-	h.debugLog("🔧 Adding ICE candidate from %s", clientID)
-	if err := peerConnection.AddICECandidate(candidate); err != nil {
-		h.debugLog("❌ Error adding ICE candidate from %s: %v", clientID, err)
+	h.debugLog("🔧 Adding ICE candidate from %s", userID)
+	return h.addRemoteCandidate(peerConnection, candidates, candidate, userID)
+}
+
+// handleVideoQuality caps the simulcast quality a client receives for a track
+func (h *Handler) handleVideoQuality(data, userID, roomID string) error {
+	request := types.VideoQualityData{}
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &request); err != nil {
+		h.debugLog("❌ Error unmarshalling video quality request from %s: %v", userID, err)
 		return err
 	}
-	return nil
+
+	switch request.Quality {
+	case types.VideoQualityLow, types.VideoQualityMedium, types.VideoQualityHigh:
+	default:
+		return fmt.Errorf("invalid video quality %q", request.Quality)
+	}
+
+	return h.trackManager.SetVideoQuality(roomID, userID, request.TrackID, request.Quality)
+}
+
+// handleSubscription changes the tracks a client receives. Empty data applies to every track.
+func (h *Handler) handleSubscription(peerConnection *webrtc.PeerConnection, data string, subscribe bool, userID, roomID string) error {
+	request := types.SubscriptionData{}
+	if data != "" {
+		if err := recovery.SafeJSONUnmarshal([]byte(data), &request); err != nil {
+			h.debugLog("❌ Error unmarshalling subscription request from %s: %v", userID, err)
+			return err
+		}
+	}
+
+	h.debugLog("📡 Subscription update from %s in room '%s' (subscribe: %t, users: %v, tracks: %v)", userID, roomID, subscribe, request.UserIDs, request.TrackIDs)
+	return h.coordinator.UpdateSubscriptions(peerConnection, roomID, subscribe, request.UserIDs, request.TrackIDs)
 }
 
 // handleAnswer processes answer messages
-func (h *Handler) handleAnswer(peerConnection *webrtc.PeerConnection, data, clientID string) error {
+func (h *Handler) handleAnswer(peerConnection *webrtc.PeerConnection, candidates *candidateBuffer, data, userID string) error {
 	answer := webrtc.SessionDescription{}
 	if err := recovery.SafeJSONUnmarshal([]byte(data), &answer); err != nil {
-		h.debugLog("❌ Error unmarshalling answer from %s: %v", clientID, err)
+		h.debugLog("❌ Error unmarshalling answer from %s: %v", userID, err)
 		return err
 	}
 
-	h.debugLog("🔄 Setting remote description (answer) from %s", clientID)
+	unlock := h.coordinator.LockNegotiation(peerConnection)
+	defer unlock()
+
+	h.debugLog("🔄 Setting remote description (answer) from %s", userID)
 	if err := peerConnection.SetRemoteDescription(answer); err != nil {
-		h.debugLog("❌ Error setting remote description from %s: %v", clientID, err)
+		h.debugLog("❌ Error setting remote description from %s: %v", userID, err)
+		return err
+	}
+
+	h.flushCandidates(peerConnection, candidates, userID)
+	return nil
+}
+
+// handleOffer answers a renegotiation offer from a client, e.g. after it added a camera.
+// The SFU is the polite side of perfect negotiation: if its own offer is outstanding when
+// the client's offer arrives, it rolls its offer back, answers, and re-offers afterwards.
+func (h *Handler) handleOffer(conn *ThreadSafeWriter, peerConnection *webrtc.PeerConnection, candidates *candidateBuffer, data, userID, roomID string) error {
+	offer := webrtc.SessionDescription{}
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &offer); err != nil {
+		h.debugLog("❌ Error unmarshalling offer from %s: %v", userID, err)
+		return err
+	}
+
+	if offer.Type != webrtc.SDPTypeOffer {
+		return fmt.Errorf("expected an offer from %s, got %s", userID, offer.Type)
+	}
+
+	rolledBack := false
+	err := func() error {
+		unlock := h.coordinator.LockNegotiation(peerConnection)
+		defer unlock()
+
+		// Glare: both sides offered at once. Being polite, drop our offer in favour of the client's.
+		if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+			h.debugLog("🔀 Offer collision with %s, rolling back our pending offer", userID)
+			rollback := webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}
+			if pending := peerConnection.PendingLocalDescription(); pending != nil {
+				rollback.SDP = pending.SDP
+			}
+			if err := peerConnection.SetLocalDescription(rollback); err != nil {
+				h.debugLog("❌ Error rolling back local offer for %s: %v", userID, err)
+				return err
+			}
+			rolledBack = true
+		}
+
+		h.debugLog("🔄 Setting remote description (offer) from %s", userID)
+		if err := peerConnection.SetRemoteDescription(offer); err != nil {
+			h.debugLog("❌ Error setting remote description from %s: %v", userID, err)
+			return err
+		}
+		h.flushCandidates(peerConnection, candidates, userID)
+
+		answer, err := peerConnection.CreateAnswer(nil)
+		if err != nil {
+			h.debugLog("❌ Error creating answer for %s: %v", userID, err)
+			return err
+		}
+
+		if err := peerConnection.SetLocalDescription(answer); err != nil {
+			h.debugLog("❌ Error setting local description for %s: %v", userID, err)
+			return err
+		}
+
+		answerString, err := recovery.SafeJSONMarshal(answer)
+		if err != nil {
+			h.debugLog("❌ Error marshalling answer for %s: %v", userID, err)
+			return err
+		}
+
+		h.debugLog("📤 Sending answer to peer %s (%d bytes)", userID, len(answerString))
+		return conn.WriteJSON(&types.WebSocketMessage{
+			Event: types.EventAnswer,
+			Data:  string(answerString),
+		})
+	}()
+	if err != nil {
 		return err
 	}
+
+	// The rolled back offer carried track changes the client still needs
+	if rolledBack {
+		h.coordinator.SignalPeerConnectionsInRoom(roomID)
+	}
 	return nil
 }
 
-// sendErrorToConnection sends an error message to a WebSocket connection
-func (h *Handler) sendErrorToConnection(conn *ThreadSafeWriter, errorMsg string) {
+// closeSession ends a client's session: it notifies the client with a reason, closes its
+// WebSocket and peer connection, and drops the peer from the WebRTC manager.
+// The room manager entry must already have been removed by the caller.
+func (h *Handler) closeSession(roomID, userID string, pc *webrtc.PeerConnection, rawConn *websocket.Conn, reason, message string) {
+	recovery.SafeExecuteWithContext("WEBSOCKET", "CLOSE_SESSION", userID, roomID, reason, func() error {
+		h.debugLog("🚫 Closing session of %s in room '%s' (reason: %s)", userID, roomID, reason)
+
+		closedData, err := recovery.SafeJSONMarshal(&types.SessionClosedData{Reason: reason, Message: message})
+		if err != nil {
+			return err
+		}
+
+		// Prefer the thread-safe writer registered for this exact peer connection
+		if peer, exists := h.webrtcManager.GetPeer(roomID, userID); exists && peer.PC == pc {
+			writer, isWriter := peer.WebSocket.(*ThreadSafeWriter)
+			if !isWriter || writer.Supports(types.FeatureSessionClosed) {
+				if writeErr := peer.WebSocket.WriteJSON(&types.WebSocketMessage{
+					Event: types.EventSessionClosed,
+					Data:  string(closedData),
+				}); writeErr != nil {
+					h.debugLog("❌ Error notifying %s of session close: %v", userID, writeErr)
+				}
+			}
+			if isWriter {
+				closeCode, known := sessionCloseCodes[reason]
+				if !known {
+					closeCode = defaultSessionCloseCode
+				}
+				writer.CloseWithReason(closeCode, reason)
+			}
+		} else if rawConn != nil {
+			rawConn.Close()
+		}
+
+		h.webrtcManager.RemovePeerFromRoom(roomID, userID, pc)
+		if pc != nil {
+			pc.Close()
+		}
+		return nil
+	})
+}
+
+// sendErrorToConnection sends a typed room_error message to a WebSocket connection
+func (h *Handler) sendErrorToConnection(conn *ThreadSafeWriter, roomError *types.RoomErrorData) {
 	recovery.SafeExecute("WEBSOCKET", "SEND_ERROR", func() error {
-		h.debugLog("❌ Sending error: %s", errorMsg)
+		h.debugLog("❌ Sending error %s: %s", roomError.Code, roomError.Message)
+
+		// Peers that did not negotiate typed errors get the plain message
+		if !conn.Supports(types.FeatureTypedErrors) {
+			return conn.WriteJSON(&types.WebSocketMessage{
+				Event: types.EventRoomError,
+				Data:  roomError.Message,
+			})
+		}
+
+		data, err := recovery.SafeJSONMarshal(roomError)
+		if err != nil {
+			return err
+		}
 		return conn.WriteJSON(&types.WebSocketMessage{
 			Event: types.EventRoomError,
-			Data:  errorMsg,
+			Data:  string(data),
+		})
+	})
+}
+
+// sendRoomJoinedToConnection sends a structured room_joined message to a WebSocket connection
+func (h *Handler) sendRoomJoinedToConnection(conn *ThreadSafeWriter, joined *types.RoomJoinedData) {
+	recovery.SafeExecute("WEBSOCKET", "SEND_SUCCESS", func() error {
+		h.debugLog("✅ Sending room joined to %s with %d ICE servers", joined.UserID, len(joined.ICEServers))
+		data, err := recovery.SafeJSONMarshal(joined)
+		if err != nil {
+			return err
+		}
+		return conn.WriteJSON(&types.WebSocketMessage{
+			Event: types.EventRoomJoined,
+			Data:  string(data),
 		})
 	})
 }
 
+// iceServersFor returns the ICE servers a client should use. TURN servers configured with a
+// shared secret get time-limited credentials bound to the user.
+func (h *Handler) iceServersFor(userID string) []types.ICEServer {
+	iceServers := []types.ICEServer{}
+	for _, server := range h.config.ICEServers {
+		credential, _ := server.Credential.(string)
+		iceServers = append(iceServers, types.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: credential,
+		})
+	}
+
+	if h.config.TURNSecret != "" {
+		username, credential := auth.TURNCredentials(h.config.TURNSecret, userID, h.config.TURNCredentialTTL, time.Now())
+		iceServers = append(iceServers, types.ICEServer{
+			URLs:       h.config.TURNServers,
+			Username:   username,
+			Credential: credential,
+		})
+	}
+	return iceServers
+}
+
 // sendSuccessToConnection sends a success message to a WebSocket connection
 func (h *Handler) sendSuccessToConnection(conn *ThreadSafeWriter, successMsg string) {
 	recovery.SafeExecute("WEBSOCKET", "SEND_SUCCESS", func() error {
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+
+	"sfu-v2/internal/auth"
+	"sfu-v2/internal/config"
+	"sfu-v2/internal/room"
+	"sfu-v2/pkg/types"
+)
+
+// subscriptionUpdate is a call to UpdateSubscriptions recorded by recordingCoordinator
+type subscriptionUpdate struct {
+	subscribe bool
+	userIDs   []string
+	trackIDs  []string
+}
+
+// recordingCoordinator records subscription updates and does nothing else
+type recordingCoordinator struct {
+	mu      sync.Mutex
+	updates []subscriptionUpdate
+}
+
+func (c *recordingCoordinator) SignalPeerConnectionsInRoom(string)            {}
+func (c *recordingCoordinator) OnTrackAddedToRoom(string)                     {}
+func (c *recordingCoordinator) OnTrackRemovedFromRoom(string)                 {}
+func (c *recordingCoordinator) LockNegotiation(*webrtc.PeerConnection) func() { return func() {} }
+func (c *recordingCoordinator) ReleasePeer(*webrtc.PeerConnection)            {}
+func (c *recordingCoordinator) OnSignalingStable(*webrtc.PeerConnection)      {}
+
+func (c *recordingCoordinator) UpdateSubscriptions(_ *webrtc.PeerConnection, _ string, subscribe bool, userIDs, trackIDs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updates = append(c.updates, subscriptionUpdate{subscribe: subscribe, userIDs: userIDs, trackIDs: trackIDs})
+	return nil
+}
+
+// runClientMessages sends raw messages to handleClientMessages over a WebSocket connection
+// and returns once the handler has processed all of them
+func runClientMessages(t *testing.T, h *Handler, messages ...string) {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc.Close()
+
+	done := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- h.handleClientMessages(&ThreadSafeWriter{Conn: conn}, pc, "room", "alice")
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	for _, message := range messages {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	if err := <-done; err != nil {
+		t.Fatalf("handleClientMessages: %v", err)
+	}
+}
+
+func TestHandleClientMessagesBareUnsubscribe(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		want     []subscriptionUpdate
+	}{
+		{
+			name:     "after an answer",
+			previous: `{"event":"answer","data":"{\"type\":\"answer\",\"sdp\":\"v=0\"}"}`,
+			want:     []subscriptionUpdate{{subscribe: false}},
+		},
+		{
+			name:     "after a subscribe to users",
+			previous: `{"event":"subscribe","data":"{\"user_ids\":[\"bob\"]}"}`,
+			want:     []subscriptionUpdate{{subscribe: true, userIDs: []string{"bob"}}, {subscribe: false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coordinator := &recordingCoordinator{}
+			h := NewHandler(&config.Config{}, nil, nil, nil, coordinator)
+
+			// A bare unsubscribe carries no data and stops every track
+			runClientMessages(t, h, tt.previous, `{"event":"unsubscribe"}`)
+
+			coordinator.mu.Lock()
+			defer coordinator.mu.Unlock()
+			if !reflect.DeepEqual(coordinator.updates, tt.want) {
+				t.Errorf("got subscription updates %+v, want %+v", coordinator.updates, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeClientJoinKeepsNonceOfRejectedJoin(t *testing.T) {
+	roomManager := room.NewManager(false, room.DuplicateSessionReject, time.Minute, nil, room.Limits{})
+	key, err := roomManager.RegisterServer("alpha", "alpha-password", "alpha-room", room.RoomLimits{})
+	if err != nil {
+		t.Fatalf("RegisterServer: %v", err)
+	}
+	if _, err := roomManager.RegisterServer("beta", "beta-password", "beta-room", room.RoomLimits{}); err != nil {
+		t.Fatalf("RegisterServer: %v", err)
+	}
+	h := NewHandler(&config.Config{}, nil, nil, roomManager, &recordingCoordinator{})
+
+	now := time.Now()
+	claims := auth.JoinClaims{UserID: "alice", RoomID: "beta-room", ServerID: "alpha", ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "n1"}
+	token, err := auth.SignJoinToken(claims, key)
+	if err != nil {
+		t.Fatalf("SignJoinToken: %v", err)
+	}
+
+	// alpha cannot admit users to beta's room, so the join fails after the token is verified
+	if _, err := h.authorizeClientJoin(&types.ClientJoinData{RoomID: "beta-room", UserToken: token}); !errors.Is(err, room.ErrRoomOwnership) {
+		t.Fatalf("got error %v, want %v", err, room.ErrRoomOwnership)
+	}
+	if !h.nonceCache.Use(claims.Nonce, claims.Expiry(), now) {
+		t.Error("nonce of a rejected join was consumed")
+	}
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"fmt"
+
+	"sfu-v2/internal/recovery"
+	"sfu-v2/pkg/types"
+)
+
+// Features the SFU offers on each endpoint
+var (
+	clientFeatures = []string{
+		types.FeatureTypedErrors,
+		types.FeatureSessionClosed,
+		types.FeatureClientOffers,
+		types.FeatureEndOfCandidates,
+		types.FeatureICEServers,
+		types.FeatureVideoQuality,
+		types.FeatureActiveSpeakers,
+		types.FeatureSubscriptions,
+	}
+	serverFeatures = []string{
+		types.FeatureTypedErrors,
+		types.FeaturePeerEvents,
+		types.FeatureRoomSnapshot,
+		types.FeatureActiveSpeakers,
+	}
+)
+
+// handleHello negotiates the protocol version and features of a connection.
+// Unsupported versions are rejected with an unsupported_version error.
+func (h *Handler) handleHello(conn *ThreadSafeWriter, clientID, data string, offered []string) error {
+	var hello types.HelloData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &hello); err != nil {
+		h.debugLog("❌ Error unmarshalling hello from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid hello data"))
+		return err
+	}
+
+	if hello.ProtocolVersion < types.MinProtocolVersion {
+		h.debugLog("❌ Rejecting %s: protocol version %d is not supported", clientID, hello.ProtocolVersion)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorUnsupportedVersion,
+			fmt.Sprintf("Protocol version %d is not supported, the SFU requires version %d to %d",
+				hello.ProtocolVersion, types.MinProtocolVersion, types.ProtocolVersion)))
+		return fmt.Errorf("unsupported protocol version %d", hello.ProtocolVersion)
+	}
+
+	// Newer peers fall back to the latest version the SFU speaks
+	version := hello.ProtocolVersion
+	if version > types.ProtocolVersion {
+		version = types.ProtocolVersion
+	}
+
+	requested := make(map[string]bool, len(hello.Features))
+	for _, feature := range hello.Features {
+		requested[feature] = true
+	}
+
+	features := []string{}
+	for _, feature := range offered {
+		if requested[feature] {
+			features = append(features, feature)
+		}
+	}
+
+	conn.SetProtocol(version, features)
+	h.debugLog("🤝 Hello from %s: protocol v%d, features %v", clientID, version, features)
+
+	reply, err := recovery.SafeJSONMarshal(&types.HelloData{
+		ProtocolVersion: version,
+		Features:        features,
+	})
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteJSON(&types.WebSocketMessage{
+		Event: types.EventHello,
+		Data:  string(reply),
+	})
+}
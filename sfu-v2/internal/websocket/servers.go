@@ -0,0 +1,419 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"sfu-v2/internal/recovery"
+	"sfu-v2/internal/room"
+	"sfu-v2/pkg/types"
+)
+
+// bindServerConnection records the /server connection that registered a server ID,
+// so presence events for that server's rooms can be pushed to it
+func (h *Handler) bindServerConnection(serverID string, conn *ThreadSafeWriter) {
+	h.serversMu.Lock()
+	defer h.serversMu.Unlock()
+
+	h.serverConns[serverID] = conn
+	h.debugLog("🖥️  Server %s bound to its /server connection", serverID)
+}
+
+// unbindServerConnection forgets every server ID bound to a closed /server connection
+func (h *Handler) unbindServerConnection(conn *ThreadSafeWriter) []string {
+	h.serversMu.Lock()
+	defer h.serversMu.Unlock()
+
+	unbound := []string{}
+	for serverID, serverConn := range h.serverConns {
+		if serverConn == conn {
+			delete(h.serverConns, serverID)
+			unbound = append(unbound, serverID)
+		}
+	}
+
+	if len(unbound) > 0 {
+		h.debugLog("🖥️  Unbound servers %v from closed /server connection", unbound)
+	}
+	return unbound
+}
+
+// handleServerDisconnect starts the grace period for every server registered over a closed
+// /server connection. Clients in rooms of servers that do not reconnect in time are told so.
+func (h *Handler) handleServerDisconnect(conn *ThreadSafeWriter) {
+	for _, serverID := range h.unbindServerConnection(conn) {
+		serverID := serverID
+		h.roomManager.MarkServerDisconnected(serverID)
+
+		time.AfterFunc(h.config.ServerGracePeriod, func() {
+			recovery.SafeExecute("WEBSOCKET", "SERVER_GRACE_EXPIRED", func() error {
+				if !h.roomManager.IsServerStale(serverID) {
+					return nil
+				}
+
+				h.debugLog("⌛ Server %s did not reconnect within %v, registration is stale", serverID, h.config.ServerGracePeriod)
+				h.notifyServerRooms(serverID, fmt.Sprintf("Server %s disconnected from the SFU; rejoining is unavailable until it reconnects", serverID))
+				return nil
+			})
+		})
+	}
+}
+
+// notifyServerRooms sends a room_error to every client in the rooms of a server.
+// Clients that did not negotiate typed errors get the plain message.
+func (h *Handler) notifyServerRooms(serverID, errorMsg string) {
+	roomError := newRoomError(types.RoomErrorServerUnavailable, errorMsg)
+
+	for _, roomID := range h.roomManager.GetRoomsForServer(serverID) {
+		for _, peer := range h.webrtcManager.GetPeersInRoom(roomID) {
+			if writer, isWriter := peer.WebSocket.(*ThreadSafeWriter); isWriter {
+				h.sendErrorToConnection(writer, roomError)
+				continue
+			}
+			if err := peer.WebSocket.WriteJSON(&types.WebSocketMessage{
+				Event: types.EventRoomError,
+				Data:  errorMsg,
+			}); err != nil {
+				h.debugLog("❌ Error notifying client in room '%s': %v", roomID, err)
+			}
+		}
+	}
+}
+
+// unbindServer forgets the /server connection of an unregistered server
+func (h *Handler) unbindServer(serverID string) {
+	h.serversMu.Lock()
+	defer h.serversMu.Unlock()
+
+	delete(h.serverConns, serverID)
+}
+
+// serverIDsForConnection returns the server IDs registered over a /server connection
+func (h *Handler) serverIDsForConnection(conn *ThreadSafeWriter) []string {
+	h.serversMu.RLock()
+	defer h.serversMu.RUnlock()
+
+	serverIDs := []string{}
+	for serverID, serverConn := range h.serverConns {
+		if serverConn == conn {
+			serverIDs = append(serverIDs, serverID)
+		}
+	}
+	return serverIDs
+}
+
+// notifyServer pushes an event to the /server connection of a registered server.
+// Events are dropped if the server is not currently connected or did not negotiate the feature.
+func (h *Handler) notifyServer(serverID, feature, event string, payload interface{}) {
+	recovery.SafeExecuteWithContext("WEBSOCKET", "NOTIFY_SERVER", "", "", fmt.Sprintf("Server: %s, Event: %s", serverID, event), func() error {
+		h.serversMu.RLock()
+		conn, exists := h.serverConns[serverID]
+		h.serversMu.RUnlock()
+
+		if !exists {
+			h.debugLog("📭 Server %s not connected, dropping %s event", serverID, event)
+			return nil
+		}
+
+		if !conn.Supports(feature) {
+			return nil
+		}
+
+		data, err := recovery.SafeJSONMarshal(payload)
+		if err != nil {
+			h.debugLog("❌ Error marshalling %s event for server %s: %v", event, serverID, err)
+			return err
+		}
+
+		return conn.WriteJSON(&types.WebSocketMessage{
+			Event: event,
+			Data:  string(data),
+		})
+	})
+}
+
+// sendJoinTokenKey tells a server which key to sign its join tokens with
+func (h *Handler) sendJoinTokenKey(conn *ThreadSafeWriter, serverID string, key []byte) {
+	recovery.SafeExecute("WEBSOCKET", "SEND_JOIN_TOKEN_KEY", func() error {
+		data, err := recovery.SafeJSONMarshal(&types.JoinTokenKeyData{
+			ServerID: serverID,
+			Key:      base64.RawURLEncoding.EncodeToString(key),
+		})
+		if err != nil {
+			return err
+		}
+
+		return conn.WriteJSON(&types.WebSocketMessage{
+			Event: types.EventJoinTokenKey,
+			Data:  string(data),
+		})
+	})
+}
+
+// notifyPeerEvent pushes a peer presence event to the server that owns the room
+func (h *Handler) notifyPeerEvent(event, serverID, roomID, userID, reason string) {
+	h.notifyServer(serverID, types.FeaturePeerEvents, event, &types.PeerEventData{
+		RoomID:    roomID,
+		UserID:    userID,
+		Reason:    reason,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// handleRoomSnapshot answers a room_snapshot request with the current room membership.
+// Only rooms owned by servers registered over this connection are included.
+func (h *Handler) handleRoomSnapshot(conn *ThreadSafeWriter, clientID, data string) error {
+	var request types.RoomSnapshotRequest
+	if data != "" {
+		if err := recovery.SafeJSONUnmarshal([]byte(data), &request); err != nil {
+			h.debugLog("❌ Error unmarshalling room snapshot request from %s: %v", clientID, err)
+			h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid room snapshot request"))
+			return err
+		}
+	}
+
+	serverIDs := h.serverIDsForConnection(conn)
+	if len(serverIDs) == 0 {
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorServerNotRegistered, "Room snapshot requires a registered server"))
+		return fmt.Errorf("room snapshot requested by unregistered connection %s", clientID)
+	}
+
+	snapshots := []types.RoomSnapshotData{}
+	for _, serverID := range serverIDs {
+		roomIDs := h.roomManager.GetRoomsForServer(serverID)
+		if request.RoomID != "" {
+			roomIDs = []string{request.RoomID}
+		}
+
+		snapshot := types.RoomSnapshotData{
+			ServerID:  serverID,
+			Rooms:     []types.RoomMembership{},
+			Timestamp: time.Now().UnixMilli(),
+		}
+
+		for _, roomID := range roomIDs {
+			ownerID, members, err := h.roomManager.SnapshotRoom(roomID)
+			if err != nil || ownerID != serverID {
+				continue
+			}
+
+			membership := types.RoomMembership{RoomID: roomID, Members: []types.RoomMember{}}
+			for _, member := range members {
+				membership.Members = append(membership.Members, types.RoomMember{
+					UserID:          member.UserID,
+					ConnectionState: member.ConnectionState,
+					IsMuted:         member.AudioState.Muted,
+					IsDeafened:      member.AudioState.Deafened,
+				})
+			}
+			snapshot.Rooms = append(snapshot.Rooms, membership)
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	h.debugLog("📸 Sending %d room snapshot(s) to %s", len(snapshots), clientID)
+	for i := range snapshots {
+		payload, err := recovery.SafeJSONMarshal(&snapshots[i])
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteJSON(&types.WebSocketMessage{
+			Event: types.EventRoomSnapshot,
+			Data:  string(payload),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeServerCommand checks that a server was registered over this connection
+func (h *Handler) authorizeServerCommand(conn *ThreadSafeWriter, serverID string) error {
+	for _, boundID := range h.serverIDsForConnection(conn) {
+		if boundID == serverID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: server %s is not registered on this connection", room.ErrInvalidCredentials, serverID)
+}
+
+// authorizeRoomCommand checks that a room is owned by a server registered over this connection
+func (h *Handler) authorizeRoomCommand(conn *ThreadSafeWriter, roomID string) (string, error) {
+	targetRoom, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", room.ErrRoomNotFound, roomID)
+	}
+
+	for _, serverID := range h.serverIDsForConnection(conn) {
+		if serverID == targetRoom.ServerID {
+			return serverID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: room %s is not owned by a server registered on this connection", room.ErrRoomOwnership, roomID)
+}
+
+// handleKickPeer forces a user out of a room at the server's request
+func (h *Handler) handleKickPeer(conn *ThreadSafeWriter, clientID, data string) error {
+	var kickData types.KickPeerData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &kickData); err != nil {
+		h.debugLog("❌ Error unmarshalling kick data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid kick data"))
+		return err
+	}
+
+	serverID, err := h.authorizeRoomCommand(conn, kickData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Kick rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Kick failed: "+err.Error()))
+		return err
+	}
+
+	evicted, err := h.roomManager.EvictPeer(kickData.RoomID, kickData.UserID)
+	if err != nil {
+		h.debugLog("❌ Kick failed for user %s: %v", kickData.UserID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Kick failed: "+err.Error()))
+		return err
+	}
+
+	message := kickData.Message
+	if message == "" {
+		message = "You were removed from the voice channel"
+	}
+
+	h.debugLog("🚫 Server %s kicked user %s from room '%s'", serverID, kickData.UserID, kickData.RoomID)
+	h.closeSession(evicted.RoomID, evicted.UserID, evicted.PeerConnection, evicted.Connection, types.SessionCloseReasonKicked, message)
+	h.notifyPeerEvent(types.EventPeerLeft, serverID, evicted.RoomID, evicted.UserID, types.SessionCloseReasonKicked)
+	h.coordinator.SignalPeerConnectionsInRoom(kickData.RoomID)
+	return nil
+}
+
+// handleCloseRoom closes a room at the server's request and disconnects everyone in it
+func (h *Handler) handleCloseRoom(conn *ThreadSafeWriter, clientID, data string) error {
+	var closeData types.CloseRoomData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &closeData); err != nil {
+		h.debugLog("❌ Error unmarshalling close room data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid close room data"))
+		return err
+	}
+
+	serverID, err := h.authorizeRoomCommand(conn, closeData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Close room rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Close room failed: "+err.Error()))
+		return err
+	}
+
+	message := closeData.Message
+	if message == "" {
+		message = "The voice channel was closed"
+	}
+
+	evicted, err := h.roomManager.CloseRoom(closeData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Error closing room '%s': %v", closeData.RoomID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Close room failed: "+err.Error()))
+		return err
+	}
+
+	h.debugLog("🚪 Server %s closed room '%s'", serverID, closeData.RoomID)
+	h.teardownEvictedSessions(serverID, evicted, types.SessionCloseReasonRoomClosed, message)
+	return nil
+}
+
+// handleRoomUnregister removes a room at the request of its owning server
+func (h *Handler) handleRoomUnregister(conn *ThreadSafeWriter, clientID, data string) error {
+	var unregData types.RoomUnregistrationData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &unregData); err != nil {
+		h.debugLog("❌ Error unmarshalling room unregistration data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid room unregistration data"))
+		return err
+	}
+
+	serverID, err := h.authorizeRoomCommand(conn, unregData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Room unregistration rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Room unregistration failed: "+err.Error()))
+		return err
+	}
+
+	evicted, err := h.roomManager.UnregisterRoom(serverID, unregData.RoomID)
+	if err != nil {
+		h.debugLog("❌ Room unregistration failed for %s: %v", unregData.RoomID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Room unregistration failed: "+err.Error()))
+		return err
+	}
+
+	h.debugLog("🗑️  Server %s unregistered room '%s'", serverID, unregData.RoomID)
+	h.teardownEvictedSessions(serverID, evicted, types.SessionCloseReasonRoomClosed, "The voice channel was closed")
+	h.sendSuccessToConnection(conn, "Room unregistered successfully")
+	return nil
+}
+
+// handleServerUnregister removes a server registration, closes all of its rooms and frees its server ID
+func (h *Handler) handleServerUnregister(conn *ThreadSafeWriter, clientID, data string) error {
+	var unregData types.ServerUnregistrationData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &unregData); err != nil {
+		h.debugLog("❌ Error unmarshalling server unregistration data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid server unregistration data"))
+		return err
+	}
+
+	if err := h.authorizeServerCommand(conn, unregData.ServerID); err != nil {
+		h.debugLog("❌ Server unregistration rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Server unregistration failed: "+err.Error()))
+		return err
+	}
+
+	evicted, err := h.roomManager.UnregisterServer(unregData.ServerID)
+	if err != nil {
+		h.debugLog("❌ Server unregistration failed for %s: %v", unregData.ServerID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Server unregistration failed: "+err.Error()))
+		return err
+	}
+
+	h.debugLog("🗑️  Server %s unregistered (%d sessions evicted)", unregData.ServerID, len(evicted))
+	h.teardownEvictedSessions(unregData.ServerID, evicted, types.SessionCloseReasonServerGone, "The server is no longer available")
+	h.sendSuccessToConnection(conn, "Server unregistered successfully")
+	h.unbindServer(unregData.ServerID)
+	return nil
+}
+
+// handleRotateCredentials swaps a server's password without disconnecting joined clients
+func (h *Handler) handleRotateCredentials(conn *ThreadSafeWriter, clientID, data string) error {
+	var rotateData types.RotateCredentialsData
+	if err := recovery.SafeJSONUnmarshal([]byte(data), &rotateData); err != nil {
+		h.debugLog("❌ Error unmarshalling credential rotation data from %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, newRoomError(types.RoomErrorInvalidMessage, "Invalid credential rotation data"))
+		return err
+	}
+
+	// Only the registering connection may rotate, so the password check below cannot be used to brute force it
+	if err := h.authorizeServerCommand(conn, rotateData.ServerID); err != nil {
+		h.debugLog("❌ Credential rotation rejected for %s: %v", clientID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Credential rotation failed: "+err.Error()))
+		return err
+	}
+
+	joinTokenKey, err := h.roomManager.RotateServerCredentials(rotateData.ServerID, rotateData.ServerPassword, rotateData.NewServerPassword)
+	if err != nil {
+		h.debugLog("❌ Credential rotation failed for server %s: %v", rotateData.ServerID, err)
+		h.sendErrorToConnection(conn, roomErrorFor(err, "Credential rotation failed: "+err.Error()))
+		return err
+	}
+
+	h.debugLog("🔑 Server %s rotated its credentials", rotateData.ServerID)
+	h.sendJoinTokenKey(conn, rotateData.ServerID, joinTokenKey)
+	h.sendSuccessToConnection(conn, "Credentials rotated successfully")
+	return nil
+}
+
+// teardownEvictedSessions closes sessions removed by the room manager and reports them to the server
+func (h *Handler) teardownEvictedSessions(serverID string, evicted []room.EvictedPeer, reason, message string) {
+	h.debugLog("🚪 Tearing down %d session(s) of server %s (reason: %s)", len(evicted), serverID, reason)
+	for _, peer := range evicted {
+		h.closeSession(peer.RoomID, peer.UserID, peer.PeerConnection, peer.Connection, reason, message)
+		h.notifyPeerEvent(types.EventPeerLeft, serverID, peer.RoomID, peer.UserID, reason)
+	}
+}
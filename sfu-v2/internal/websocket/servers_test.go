@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+
+	"sfu-v2/internal/config"
+	"sfu-v2/internal/room"
+)
+
+func TestAuthorizeServerCommand(t *testing.T) {
+	h := NewHandler(&config.Config{}, nil, nil, nil, &recordingCoordinator{})
+	registering, other := &ThreadSafeWriter{}, &ThreadSafeWriter{}
+	h.bindServerConnection("alpha", registering)
+
+	tests := []struct {
+		name     string
+		conn     *ThreadSafeWriter
+		serverID string
+		wantErr  bool
+	}{
+		{"registering connection", registering, "alpha", false},
+		{"other connection", other, "alpha", true},
+		{"server registered elsewhere", registering, "beta", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.authorizeServerCommand(tt.conn, tt.serverID)
+			if tt.wantErr && !errors.Is(err, room.ErrInvalidCredentials) {
+				t.Errorf("got error %v, want %v", err, room.ErrInvalidCredentials)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
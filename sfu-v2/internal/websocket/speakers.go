@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"time"
+
+	"sfu-v2/internal/recovery"
+	"sfu-v2/pkg/types"
+)
+
+// BroadcastActiveSpeakers pushes the ranked speakers of a room to its clients and to the
+// server that owns it. Only connections that negotiated active speakers receive the event.
+func (h *Handler) BroadcastActiveSpeakers(roomID string, speakers []types.ActiveSpeaker) {
+	recovery.SafeExecuteWithContext("WEBSOCKET", "BROADCAST_ACTIVE_SPEAKERS", "", roomID, "Broadcasting active speakers", func() error {
+		payload := &types.ActiveSpeakersData{
+			RoomID:    roomID,
+			Speakers:  speakers,
+			Timestamp: time.Now().UnixMilli(),
+		}
+
+		data, err := recovery.SafeJSONMarshal(payload)
+		if err != nil {
+			h.debugLog("❌ Error marshalling active speakers for room '%s': %v", roomID, err)
+			return err
+		}
+
+		for _, peer := range h.webrtcManager.GetPeersInRoom(roomID) {
+			writer, isWriter := peer.WebSocket.(*ThreadSafeWriter)
+			if !isWriter || !writer.Supports(types.FeatureActiveSpeakers) {
+				continue
+			}
+			if err := writer.WriteJSON(&types.WebSocketMessage{
+				Event: types.EventActiveSpeakers,
+				Data:  string(data),
+			}); err != nil {
+				h.debugLog("❌ Error sending active speakers to client in room '%s': %v", roomID, err)
+			}
+		}
+
+		if room, exists := h.roomManager.GetRoom(roomID); exists {
+			h.notifyServer(room.ServerID, types.FeatureActiveSpeakers, types.EventActiveSpeakers, payload)
+		}
+		return nil
+	})
+}
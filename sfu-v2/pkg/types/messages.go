@@ -6,11 +6,70 @@ type WebSocketMessage struct {
 	Data  string `json:"data"`
 }
 
+// HelloData is exchanged in the hello handshake that opens a connection.
+// The peer sends the protocol version it speaks and the optional features it understands;
+// the SFU answers with the negotiated version and the features both sides support.
+type HelloData struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Features        []string `json:"features"`
+}
+
+// Signaling protocol versions supported by the SFU
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// Optional protocol features negotiated in the hello handshake
+const (
+	FeatureTypedErrors     = "typed_errors"
+	FeatureSessionClosed   = "session_closed"
+	FeaturePeerEvents      = "peer_events"
+	FeatureRoomSnapshot    = "room_snapshot"
+	FeatureClientOffers    = "client_offers"
+	FeatureEndOfCandidates = "end_of_candidates"
+	FeatureICEServers      = "ice_servers"
+	FeatureVideoQuality    = "video_quality"
+	FeatureActiveSpeakers  = "active_speakers"
+	FeatureSubscriptions   = "subscriptions"
+)
+
 // ServerRegistrationData represents server registration information
 type ServerRegistrationData struct {
 	ServerID       string `json:"server_id"`
 	ServerPassword string `json:"server_password"`
 	RoomID         string `json:"room_id"`
+	// Optional per-room limits for this server's rooms, zero uses the SFU defaults
+	MaxPeersPerRoom  int `json:"max_peers_per_room,omitempty"`
+	MaxTracksPerRoom int `json:"max_tracks_per_room,omitempty"`
+	// LastN forwards only the audio of the N most active speakers in each room
+	LastN int `json:"last_n,omitempty"`
+}
+
+// RoomUnregistrationData represents a request to remove a room registered over the same connection
+type RoomUnregistrationData struct {
+	ServerID string `json:"server_id"`
+	RoomID   string `json:"room_id"`
+}
+
+// ServerUnregistrationData represents a request to remove a server registration and all its rooms
+type ServerUnregistrationData struct {
+	ServerID string `json:"server_id"`
+}
+
+// RotateCredentialsData represents a request to replace a server's password
+type RotateCredentialsData struct {
+	ServerID          string `json:"server_id"`
+	ServerPassword    string `json:"server_password"`
+	NewServerPassword string `json:"new_server_password"`
+}
+
+// JoinTokenKeyData carries the key a server signs join tokens with. It is sent after every
+// successful registration and credential rotation.
+type JoinTokenKeyData struct {
+	ServerID string `json:"server_id"`
+	// Key is the raw HMAC-SHA256 key, base64url-encoded without padding
+	Key string `json:"key"`
 }
 
 // ClientJoinData represents client join information
@@ -21,14 +80,211 @@ type ClientJoinData struct {
 	UserToken      string `json:"user_token"`
 }
 
+// UserAudioControlData represents a server-driven mute/deafen update for a user
+type UserAudioControlData struct {
+	RoomID     string `json:"room_id"`
+	UserID     string `json:"user_id"`
+	ServerID   string `json:"server_id"`
+	IsMuted    bool   `json:"is_muted"`
+	IsDeafened bool   `json:"is_deafened"`
+}
+
+// SessionClosedData tells a client why the SFU is ending its session
+type SessionClosedData struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// RoomErrorData is the typed payload of a room_error message
+type RoomErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Retryable tells the client whether repeating the same request may succeed
+	Retryable bool `json:"retryable"`
+	// RetryAfter is the suggested delay in seconds before retrying, zero if there is no hint
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// Codes reported in room_error messages
+const (
+	RoomErrorInvalidMessage      = "invalid_message"
+	RoomErrorUnsupportedVersion  = "unsupported_version"
+	RoomErrorInvalidRequest      = "invalid_request"
+	RoomErrorUnauthorized        = "unauthorized"
+	RoomErrorTokenExpired        = "token_expired"
+	RoomErrorServerNotRegistered = "server_not_registered"
+	RoomErrorServerUnavailable   = "server_unavailable"
+	RoomErrorRoomNotFound        = "room_not_found"
+	RoomErrorRoomConflict        = "room_conflict"
+	RoomErrorUserNotFound        = "user_not_found"
+	RoomErrorDuplicateSession    = "duplicate_session"
+	RoomErrorRoomFull            = "room_full"
+	RoomErrorServerCapacity      = "server_capacity"
+	RoomErrorTrackLimit          = "track_limit"
+	RoomErrorInternal            = "internal_error"
+)
+
+// ICEServer describes an ICE server in the shape of the browser's RTCIceServer
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// RoomJoinedData is the room_joined payload for clients that negotiated the ice_servers feature
+type RoomJoinedData struct {
+	RoomID     string      `json:"room_id"`
+	UserID     string      `json:"user_id"`
+	Message    string      `json:"message"`
+	ICEServers []ICEServer `json:"ice_servers"`
+}
+
+// KickPeerData represents a server request to force a user out of a room
+type KickPeerData struct {
+	RoomID  string `json:"room_id"`
+	UserID  string `json:"user_id"`
+	Message string `json:"message,omitempty"`
+}
+
+// CloseRoomData represents a server request to close a room and disconnect everyone in it
+type CloseRoomData struct {
+	RoomID  string `json:"room_id"`
+	Message string `json:"message,omitempty"`
+}
+
+// Reasons reported in session_closed messages
+const (
+	SessionCloseReasonReplaced   = "replaced"
+	SessionCloseReasonKicked     = "kicked"
+	SessionCloseReasonRoomClosed = "room_closed"
+	SessionCloseReasonServerGone = "server_unregistered"
+)
+
+// PeerEventData represents a presence change of a peer pushed to the owning server
+type PeerEventData struct {
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// RoomSnapshotRequest asks for the current membership of a room, or of all the server's rooms if RoomID is empty
+type RoomSnapshotRequest struct {
+	RoomID string `json:"room_id,omitempty"`
+}
+
+// RoomMember represents a user's session in a room snapshot
+type RoomMember struct {
+	UserID          string `json:"user_id"`
+	ConnectionState string `json:"connection_state"`
+	IsMuted         bool   `json:"is_muted"`
+	IsDeafened      bool   `json:"is_deafened"`
+}
+
+// RoomMembership represents the members of a single room in a snapshot
+type RoomMembership struct {
+	RoomID  string       `json:"room_id"`
+	Members []RoomMember `json:"members"`
+}
+
+// RoomSnapshotData is the response to a room_snapshot request
+type RoomSnapshotData struct {
+	ServerID  string           `json:"server_id"`
+	Rooms     []RoomMembership `json:"rooms"`
+	Timestamp int64            `json:"timestamp"`
+}
+
+// VideoQualityData is sent by a client to cap the simulcast quality it receives for a track,
+// for example when the video is shown as a thumbnail. Within the cap the SFU picks the
+// highest layer the client's bandwidth allows.
+type VideoQualityData struct {
+	TrackID string `json:"track_id"`
+	Quality string `json:"quality"`
+}
+
+// Video qualities a client may request, mapped to the lowest, middle and highest simulcast layer
+const (
+	VideoQualityLow    = "low"
+	VideoQualityMedium = "medium"
+	VideoQualityHigh   = "high"
+)
+
+// SubscriptionData is sent by a client to choose which tracks it receives. A subscribe or
+// unsubscribe event applies to all tracks of the named users and to the named tracks; naming
+// neither applies to every track in the room. Rules naming a track override rules naming its user.
+type SubscriptionData struct {
+	UserIDs  []string `json:"user_ids,omitempty"`
+	TrackIDs []string `json:"track_ids,omitempty"`
+}
+
+// ActiveSpeaker is a user ranked among the loudest speakers of a room
+type ActiveSpeaker struct {
+	UserID string `json:"user_id"`
+	// Level is the user's average loudness over the detection window, from 0 to 1
+	Level float64 `json:"level"`
+}
+
+// ActiveSpeakersData is pushed to clients and the owning server when the loudest speakers
+// of a room change. Speakers are ordered loudest first and empty when nobody speaks.
+type ActiveSpeakersData struct {
+	RoomID    string          `json:"room_id"`
+	Speakers  []ActiveSpeaker `json:"speakers"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// ICECandidateStats counts the remote ICE candidates handled by the SFU
+type ICECandidateStats struct {
+	Added    uint64 `json:"added"`
+	Buffered uint64 `json:"buffered"`
+	Flushed  uint64 `json:"flushed"`
+	// Dropped candidates arrived too early: the buffer was full or the session ended first
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
+
+// CodecStats counts the forwarded tracks using a negotiated codec
+type CodecStats struct {
+	MimeType    string `json:"mime_type"`
+	ClockRate   uint32 `json:"clock_rate"`
+	Channels    uint16 `json:"channels,omitempty"`
+	SDPFmtpLine string `json:"sdp_fmtp_line,omitempty"`
+	Tracks      int    `json:"tracks"`
+}
+
+// StatsData is served by the /stats endpoint
+type StatsData struct {
+	ICECandidates ICECandidateStats `json:"ice_candidates"`
+	Codecs        []CodecStats      `json:"codecs"`
+	Timestamp     int64             `json:"timestamp"`
+}
+
 // Supported WebSocket message events
 const (
-	EventOffer          = "offer"
-	EventAnswer         = "answer"
-	EventCandidate      = "candidate"
-	EventServerRegister = "server_register"
-	EventClientJoin     = "client_join"
-	EventRoomJoined     = "room_joined"
-	EventRoomError      = "room_error"
-	EventKeepAlive      = "keep_alive"
+	EventHello             = "hello"
+	EventOffer             = "offer"
+	EventAnswer            = "answer"
+	EventCandidate         = "candidate"
+	EventEndOfCandidates   = "end_of_candidates"
+	EventServerRegister    = "server_register"
+	EventClientJoin        = "client_join"
+	EventRoomJoined        = "room_joined"
+	EventRoomError         = "room_error"
+	EventKeepAlive         = "keep_alive"
+	EventUserAudioControl  = "user_audio_control"
+	EventSessionClosed     = "session_closed"
+	EventPeerJoined        = "peer_joined"
+	EventPeerLeft          = "peer_left"
+	EventPeerConnected     = "peer_connected"
+	EventPeerFailed        = "peer_failed"
+	EventRoomSnapshot      = "room_snapshot"
+	EventKickPeer          = "kick_peer"
+	EventCloseRoom         = "close_room"
+	EventServerUnregister  = "server_unregister"
+	EventRoomUnregister    = "room_unregister"
+	EventRotateCredentials = "rotate_credentials"
+	EventJoinTokenKey      = "join_token_key"
+	EventVideoQuality      = "video_quality"
+	EventActiveSpeakers    = "active_speakers"
+	EventSubscribe         = "subscribe"
+	EventUnsubscribe       = "unsubscribe"
 )